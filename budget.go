@@ -0,0 +1,273 @@
+package zapgcl
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	gcl "cloud.google.com/go/logging"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/time/rate"
+)
+
+const (
+	// DefaultBudgetInterval is the Budget interval used if none is given.
+	DefaultBudgetInterval = time.Minute
+
+	// SuppressedKey is the payload field of summary entries which lists the
+	// entries suppressed by a Budget.
+	SuppressedKey = "suppressed"
+
+	// maxSuppressedMessages caps the number of distinct messages a summary
+	// lists; the rest are counted under an empty message.
+	maxSuppressedMessages = 100
+)
+
+// A Rate is a token bucket: PerSecond entries a second are allowed on
+// average, with bursts of up to Burst entries.
+type Rate struct {
+	PerSecond float64
+	Burst     int
+}
+
+// A Budget limits what a Core sends to Stackdriver, to keep ingestion costs
+// under control. It doesn't affect the core being teed, unlike sampling
+// configured on the zap.Logger.
+type Budget struct {
+	// Rates limits the entries of each level. Levels which aren't in the
+	// map are not rate limited.
+	Rates map[zapcore.Level]Rate
+
+	// Bytes limits the estimated encoded size of the entries sent in each
+	// Interval. Zero means no limit.
+	Bytes int
+
+	// Interval is the period of the Bytes budget, and how often a summary
+	// of the suppressed entries is written. It defaults to
+	// DefaultBudgetInterval.
+	Interval time.Duration
+}
+
+// WithBudget limits what the Core sends to Stackdriver. Whenever entries
+// have been suppressed, a Notice entry with a SuppressedKey field counting
+// them by level and message is written, at most once an Interval: by the
+// next Write once the Interval is over, or by a timer if nothing is written,
+// and by Sync straight away.
+func WithBudget(b Budget) Option {
+	return optionFunc(func(o *options) {
+		o.budget = &b
+	})
+}
+
+// suppressedKey identifies the entries a summary counts together.
+type suppressedKey struct {
+	level   zapcore.Level
+	message string
+}
+
+// budget is the state of a Budget. It is shared by a Core and all of its
+// children.
+type budget struct {
+	now      func() time.Time
+	limiters map[zapcore.Level]*rate.Limiter
+	bytes    int
+	interval time.Duration
+
+	mu          sync.Mutex
+	windowStart time.Time
+	used        int
+	lastSummary time.Time
+	suppressed  map[suppressedKey]int
+	droppedSize int
+
+	// flush writes the summary if it's due. The timer calls it once the
+	// interval is over, so that summaries are written even if nothing else
+	// is logged; it's stopped for good once stopped is set.
+	flush   func()
+	timer   *time.Timer
+	stopped bool
+}
+
+func newBudget(b *Budget) *budget {
+	bs := &budget{
+		now:        time.Now,
+		limiters:   make(map[zapcore.Level]*rate.Limiter, len(b.Rates)),
+		bytes:      b.Bytes,
+		interval:   b.Interval,
+		suppressed: make(map[suppressedKey]int),
+	}
+	if bs.interval <= 0 {
+		bs.interval = DefaultBudgetInterval
+	}
+	for l, r := range b.Rates {
+		bs.limiters[l] = rate.NewLimiter(rate.Limit(r.PerSecond), r.Burst)
+	}
+	bs.windowStart = bs.now()
+	bs.lastSummary = bs.windowStart
+	return bs
+}
+
+// allow reports whether an entry may be sent, as far as its level's rate is
+// concerned, and counts it as suppressed if not.
+func (b *budget) allow(ze zapcore.Entry) bool {
+	l, ok := b.limiters[ze.Level]
+	if !ok || l.AllowN(b.now(), 1) {
+		return true
+	}
+	b.mu.Lock()
+	b.suppress(ze, 0)
+	b.mu.Unlock()
+	return false
+}
+
+// spend reports whether size more bytes fit in the current interval, taking
+// them if so and counting the entry as suppressed if not.
+func (b *budget) spend(ze zapcore.Entry, size int) bool {
+	if b.bytes <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.roll()
+	if b.used+size > b.bytes {
+		b.suppress(ze, size)
+		return false
+	}
+	b.used += size
+	return true
+}
+
+// roll starts a new interval if the current one is over. b.mu must be held.
+func (b *budget) roll() {
+	if now := b.now(); now.Sub(b.windowStart) >= b.interval {
+		b.windowStart = now
+		b.used = 0
+	}
+}
+
+// suppress counts a suppressed entry. b.mu must be held.
+func (b *budget) suppress(ze zapcore.Entry, size int) {
+	k := suppressedKey{level: ze.Level, message: ze.Message}
+	if _, ok := b.suppressed[k]; !ok && len(b.suppressed) >= maxSuppressedMessages {
+		k.message = ""
+	}
+	b.suppressed[k]++
+	b.droppedSize += size
+	b.schedule()
+}
+
+// schedule arms the timer for the end of the interval, unless it's armed
+// already. b.mu must be held.
+func (b *budget) schedule() {
+	if b.timer != nil || b.stopped || b.flush == nil {
+		return
+	}
+	b.timer = time.AfterFunc(b.lastSummary.Add(b.interval).Sub(b.now()), b.fire)
+}
+
+// fire writes the summary when the timer goes off, and arms it again if
+// entries are still waiting for one.
+func (b *budget) fire() {
+	b.mu.Lock()
+	b.timer = nil
+	b.mu.Unlock()
+
+	b.flush()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.suppressed) > 0 {
+		b.schedule()
+	}
+}
+
+// stopTimer stops the timer, for good if stop is set.
+func (b *budget) stopTimer(stop bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+	if stop {
+		b.stopped = true
+	}
+}
+
+// summary returns an entry reporting what has been suppressed since the last
+// summary, if anything has been and either the interval is over or force is
+// set.
+func (b *budget) summary(force bool) (gcl.Entry, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.suppressed) == 0 {
+		return gcl.Entry{}, false
+	}
+	now := b.now()
+	if !force && now.Sub(b.lastSummary) < b.interval {
+		return gcl.Entry{}, false
+	}
+	b.lastSummary = now
+
+	keys := make([]suppressedKey, 0, len(b.suppressed))
+	total := 0
+	for k, n := range b.suppressed {
+		keys = append(keys, k)
+		total += n
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].level != keys[j].level {
+			return keys[i].level > keys[j].level
+		}
+		return keys[i].message < keys[j].message
+	})
+	list := make([]interface{}, len(keys))
+	for i, k := range keys {
+		list[i] = map[string]interface{}{
+			"level":   k.level.String(),
+			"message": k.message,
+			"count":   b.suppressed[k],
+		}
+	}
+
+	payload := map[string]interface{}{
+		"message":     "entries suppressed by the logging budget",
+		"count":       total,
+		SuppressedKey: list,
+	}
+	if b.droppedSize > 0 {
+		payload["bytes"] = b.droppedSize
+	}
+	b.suppressed = make(map[suppressedKey]int)
+	b.droppedSize = 0
+
+	return gcl.Entry{Timestamp: now, Severity: gcl.Notice, Payload: payload}, true
+}
+
+// writeSummary writes the budget summary to the Core's Logger if one is due,
+// or, with force, if anything has been suppressed, in which case the timer is
+// no longer needed. The messages it lists are redacted like those of the
+// entries themselves.
+func (c *Core) writeSummary(force bool) {
+	if c.budget == nil {
+		return
+	}
+	if e, ok := c.budget.summary(force); ok {
+		e.Resource = c.Resource
+		c.redact(&e, e.Payload.(map[string]interface{}))
+		c.Logger.Log(e)
+	}
+	if force {
+		c.budget.stopTimer(false)
+	}
+}
+
+// stopSummaries writes the pending budget summary, if any, and stops the
+// timer for good. Closer.Close calls it.
+func (c *Core) stopSummaries() {
+	if c.budget == nil {
+		return
+	}
+	c.budget.stopTimer(true)
+	c.writeSummary(true)
+}
@@ -0,0 +1,174 @@
+package zapgcl
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	gcl "cloud.google.com/go/logging"
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestCoreBudget(t *testing.T) {
+	l := &testLogger{}
+	c, err := NewCore(nil, "", WithGoogleCloudLogger(l), WithLevel(zapcore.DebugLevel),
+		WithBudget(Budget{
+			Rates:    map[zapcore.Level]Rate{zapcore.DebugLevel: {PerSecond: 1, Burst: 2}},
+			Bytes:    5000,
+			Interval: time.Minute,
+		}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1000, 0)
+	c.budget.now = func() time.Time { return now }
+	c.budget.windowStart, c.budget.lastSummary = now, now
+
+	// The budget is shared with children.
+	child := c.With([]zapcore.Field{zap.String("k", "v")})
+	for i := 0; i < 5; i++ {
+		if err := child.Write(zapcore.Entry{Level: zapcore.DebugLevel, Message: "chatty"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(l.entries) != 2 {
+		t.Fatalf("expected the burst to be let through, got %d entries", len(l.entries))
+	}
+
+	// Info isn't rate limited, but the byte budget still applies.
+	big := zap.String("big", strings.Repeat("x", 1000))
+	for i := 0; i < 2; i++ {
+		if err := c.Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: "big"}, []zapcore.Field{big}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(l.entries) != 3 {
+		t.Fatalf("expected the byte budget to stop the second entry, got %d entries", len(l.entries))
+	}
+
+	// The summary waits for the interval to be over.
+	now = now.Add(time.Minute)
+	if err := c.Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: "after"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.entries) != 5 {
+		t.Fatalf("expected a summary and a new entry, got %d entries", len(l.entries))
+	}
+	summary := l.entries[3]
+	if summary.Severity != gcl.Notice {
+		t.Errorf("unexpected summary severity %v", summary.Severity)
+	}
+	p := summary.Payload.(map[string]interface{})
+	expected := []interface{}{
+		map[string]interface{}{"level": "info", "message": "big", "count": 1},
+		map[string]interface{}{"level": "debug", "message": "chatty", "count": 3},
+	}
+	if diff := cmp.Diff(expected, p[SuppressedKey]); diff != "" {
+		t.Error(diff)
+	}
+	if p["count"] != 4 || p["bytes"].(int) < 1000 {
+		t.Errorf("unexpected totals %v, %v", p["count"], p["bytes"])
+	}
+
+	// Sync writes the summary straight away.
+	for i := 0; i < 3; i++ {
+		c.Write(zapcore.Entry{Level: zapcore.DebugLevel, Message: "chatty"}, nil)
+	}
+	n := len(l.entries)
+	c.Sync()
+	if len(l.entries) != n+1 {
+		t.Error("expected Sync to write a summary")
+	}
+}
+
+func TestCoreBudgetRedaction(t *testing.T) {
+	l := &testLogger{}
+	c, err := NewCore(nil, "", WithGoogleCloudLogger(l),
+		WithBudget(Budget{Rates: map[zapcore.Level]Rate{zapcore.InfoLevel: {PerSecond: 1, Burst: 1}}}),
+		WithRedaction(Redaction{Rules: []RedactionRule{{Values: []*regexp.Regexp{EmailPattern}}}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: "login bob@example.com"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c.Sync()
+	if len(l.entries) != 2 {
+		t.Fatalf("expected an entry and a summary, got %d entries", len(l.entries))
+	}
+	expected := []interface{}{
+		map[string]interface{}{"level": "info", "message": "login " + DefaultRedactionMask, "count": 1},
+	}
+	if diff := cmp.Diff(expected, l.entries[1].Payload.(map[string]interface{})[SuppressedKey]); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestCoreBudgetSplit(t *testing.T) {
+	l := &testLogger{}
+	c, err := NewCore(nil, "", WithGoogleCloudLogger(l),
+		WithOversizePolicy(OversizeSplit, 4096),
+		WithBudget(Budget{Bytes: 9000}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	big := zap.String("big", strings.Repeat("x", 20000))
+	if err := c.Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: "big"}, []zapcore.Field{big}); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.entries) != 0 {
+		t.Fatalf("expected the split entry to be suppressed as a whole, got %d pieces", len(l.entries))
+	}
+
+	small := zap.String("big", strings.Repeat("x", 4000))
+	if err := c.Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: "small"}, []zapcore.Field{small}); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.entries) < 2 {
+		t.Fatalf("expected every piece to be sent, got %d", len(l.entries))
+	}
+	if n := l.entries[0].Payload.(map[string]interface{})[SplitKey].(map[string]interface{})["totalSplits"]; n != len(l.entries) {
+		t.Errorf("expected %v pieces, got %d", n, len(l.entries))
+	}
+}
+
+func TestCoreBudgetSummaryTimer(t *testing.T) {
+	l := &testLogger{}
+	c, err := NewCore(nil, "", WithGoogleCloudLogger(l),
+		WithBudget(Budget{Rates: map[zapcore.Level]Rate{zapcore.InfoLevel: {PerSecond: 0.001, Burst: 1}}, Interval: 50 * time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		c.Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: "burst"}, nil)
+	}
+
+	// Nothing else is logged, but the summary still comes.
+	for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		l.mu.Lock()
+		n := len(l.entries)
+		l.mu.Unlock()
+		if n > 1 {
+			break
+		}
+	}
+	l.mu.Lock()
+	if len(l.entries) != 2 || l.entries[1].Payload.(map[string]interface{})["count"] != 2 {
+		t.Fatalf("expected a summary of two entries, got %+v", l.entries)
+	}
+	l.mu.Unlock()
+
+	// Once the Core is stopped, no timer is armed any more.
+	c.stopSummaries()
+	c.Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: "burst"}, nil)
+	c.budget.mu.Lock()
+	defer c.budget.mu.Unlock()
+	if c.budget.timer != nil {
+		t.Error("expected no timer once stopped")
+	}
+}
@@ -0,0 +1,291 @@
+package zapgcl
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	gcl "cloud.google.com/go/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Config is a declarative configuration of a Logger writing to Cloud Logging.
+// It embeds a zap.Config, for the local output, and can be read from the
+// same YAML or JSON document, for example:
+//
+//	level: info
+//	encoding: json
+//	outputPaths: [stderr]
+//	errorOutputPaths: [stderr]
+//	projectID: my-project
+//	logID: my-log
+//	labels: {env: prod}
+//	resource: auto
+//	severityMapping: {warn: NOTICE}
+//	gclLevel: warn
+//	buffering: {delayThreshold: 2s, entryCountThreshold: 500}
+//
+// Fields which are left empty keep the defaults of NewWithOptions. Values in
+// ZAPGCL_* environment variables take precedence (see ApplyEnv).
+type Config struct {
+	zap.Config `json:",inline" yaml:",inline"`
+
+	// ProjectID is the project the client writes to. If empty, it's taken
+	// from the GOOGLE_CLOUD_PROJECT environment variable.
+	ProjectID string `json:"projectID" yaml:"projectID"`
+
+	// LogID is the ID of the log entries are written to.
+	LogID string `json:"logID" yaml:"logID"`
+
+	// Labels are added to every entry (see WithCommonLabels).
+	Labels map[string]string `json:"labels,omitempty" yaml:"labels,omitempty"`
+
+	// Resource is "auto" to detect the MonitoredResource (see
+	// WithResourceDetection), or the type of the resource, whose labels
+	// are ResourceLabels. If empty, the GCL client detects the resource.
+	Resource       string            `json:"resource,omitempty" yaml:"resource,omitempty"`
+	ResourceLabels map[string]string `json:"resourceLabels,omitempty" yaml:"resourceLabels,omitempty"`
+
+	// SeverityMapping maps level names, such as "warn", to severity names,
+	// such as "NOTICE". Levels which aren't in it keep the severity of
+	// DefaultSeverityMapping.
+	SeverityMapping map[string]string `json:"severityMapping,omitempty" yaml:"severityMapping,omitempty"`
+
+	// GCLLevel is the minimum level of the entries sent to Cloud Logging.
+	// If empty, the level of the local output applies.
+	GCLLevel string `json:"gclLevel,omitempty" yaml:"gclLevel,omitempty"`
+
+	// Buffering sets how the client batches entries.
+	Buffering BufferingConfig `json:"buffering,omitempty" yaml:"buffering,omitempty"`
+}
+
+// BufferingConfig sets the thresholds of the gcl.LoggerOptions of the same
+// names. Zero values keep the defaults of the GCL client.
+type BufferingConfig struct {
+	DelayThreshold      Duration `json:"delayThreshold,omitempty" yaml:"delayThreshold,omitempty"`
+	EntryCountThreshold int      `json:"entryCountThreshold,omitempty" yaml:"entryCountThreshold,omitempty"`
+	EntryByteThreshold  int      `json:"entryByteThreshold,omitempty" yaml:"entryByteThreshold,omitempty"`
+	EntryByteLimit      int      `json:"entryByteLimit,omitempty" yaml:"entryByteLimit,omitempty"`
+	BufferedByteLimit   int      `json:"bufferedByteLimit,omitempty" yaml:"bufferedByteLimit,omitempty"`
+}
+
+// Duration is a time.Duration which is encoded as text, such as "1.5s".
+type Duration time.Duration
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// envOverrides are the environment variables ApplyEnv reads, and how they
+// set the Config.
+var envOverrides = []struct {
+	name string
+	set  func(cfg *Config, v string) error
+}{
+	{"ZAPGCL_PROJECT_ID", func(cfg *Config, v string) error { cfg.ProjectID = v; return nil }},
+	{"ZAPGCL_LOG_ID", func(cfg *Config, v string) error { cfg.LogID = v; return nil }},
+	{"ZAPGCL_LEVEL", func(cfg *Config, v string) error {
+		l, err := zapcore.ParseLevel(v)
+		if err != nil {
+			return err
+		}
+		// A new AtomicLevel, since the current one may be shared.
+		cfg.Level = zap.NewAtomicLevelAt(l)
+		return nil
+	}},
+	{"ZAPGCL_GCL_LEVEL", func(cfg *Config, v string) error { cfg.GCLLevel = v; return nil }},
+	{"ZAPGCL_LABELS", func(cfg *Config, v string) error {
+		return mergeEnvMap(&cfg.Labels, v)
+	}},
+	{"ZAPGCL_RESOURCE", func(cfg *Config, v string) error { cfg.Resource = v; return nil }},
+	{"ZAPGCL_RESOURCE_LABELS", func(cfg *Config, v string) error {
+		return mergeEnvMap(&cfg.ResourceLabels, v)
+	}},
+	{"ZAPGCL_SEVERITY_MAPPING", func(cfg *Config, v string) error {
+		return mergeEnvMap(&cfg.SeverityMapping, v)
+	}},
+	{"ZAPGCL_DELAY_THRESHOLD", func(cfg *Config, v string) error {
+		return cfg.Buffering.DelayThreshold.UnmarshalText([]byte(v))
+	}},
+	{"ZAPGCL_ENTRY_COUNT_THRESHOLD", envInt(func(cfg *Config) *int { return &cfg.Buffering.EntryCountThreshold })},
+	{"ZAPGCL_ENTRY_BYTE_THRESHOLD", envInt(func(cfg *Config) *int { return &cfg.Buffering.EntryByteThreshold })},
+	{"ZAPGCL_ENTRY_BYTE_LIMIT", envInt(func(cfg *Config) *int { return &cfg.Buffering.EntryByteLimit })},
+	{"ZAPGCL_BUFFERED_BYTE_LIMIT", envInt(func(cfg *Config) *int { return &cfg.Buffering.BufferedByteLimit })},
+}
+
+// mergeEnvMap merges the "key:value,key:value" pairs of v into a copy of *m,
+// so that maps shared with other Configs are left alone.
+func mergeEnvMap(m *map[string]string, v string) error {
+	merged := make(map[string]string, len(*m))
+	for k, mv := range *m {
+		merged[k] = mv
+	}
+	if err := parseLabels(v, merged); err != nil {
+		return err
+	}
+	*m = merged
+	return nil
+}
+
+func envInt(field func(cfg *Config) *int) func(cfg *Config, v string) error {
+	return func(cfg *Config, v string) error {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return err
+		}
+		*field(cfg) = n
+		return nil
+	}
+}
+
+// ApplyEnv overrides the Config with the ZAPGCL_* environment variables
+// which are set: ZAPGCL_PROJECT_ID, ZAPGCL_LOG_ID, ZAPGCL_LEVEL (the level
+// of the zap.Config), ZAPGCL_GCL_LEVEL, ZAPGCL_RESOURCE,
+// ZAPGCL_DELAY_THRESHOLD, ZAPGCL_ENTRY_COUNT_THRESHOLD,
+// ZAPGCL_ENTRY_BYTE_THRESHOLD, ZAPGCL_ENTRY_BYTE_LIMIT and
+// ZAPGCL_BUFFERED_BYTE_LIMIT replace values, while ZAPGCL_LABELS,
+// ZAPGCL_RESOURCE_LABELS and ZAPGCL_SEVERITY_MAPPING, of the form
+// "key:value,key:value", are merged into them.
+func (cfg *Config) ApplyEnv() error {
+	for _, o := range envOverrides {
+		v, ok := os.LookupEnv(o.name)
+		if !ok {
+			continue
+		}
+		if err := o.set(cfg, v); err != nil {
+			return newError("config %s: %v", o.name, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks the Cloud Logging part of the Config. Errors name the
+// offending key.
+func (cfg Config) Validate() error {
+	_, err := cfg.options()
+	return err
+}
+
+// Build applies the environment overrides to a copy of the Config, checks
+// it and builds a Logger, like NewProductionWithCloser does, writing to a
+// client of its own. opts are applied after, and so override, those derived
+// from the Config.
+func (cfg Config) Build(opts ...Option) (*zap.Logger, *Closer, error) {
+	if err := cfg.ApplyEnv(); err != nil {
+		return nil, nil, err
+	}
+	if cfg.ProjectID == "" {
+		cfg.ProjectID = projectIDFromEnv()
+	}
+	if cfg.ProjectID == "" {
+		return nil, nil, configError("projectID", "must not be empty")
+	}
+	copts, err := cfg.options()
+	if err != nil {
+		return nil, nil, err
+	}
+	return newWithClient(cfg.Config, cfg.ProjectID, cfg.LogID, append(copts, opts...))
+}
+
+// configError returns an error naming the key of the Config at fault.
+func configError(key string, format string, args ...interface{}) error {
+	return newError("config %s: %s", key, fmt.Sprintf(format, args...))
+}
+
+// options checks the Config and returns the Options it stands for.
+func (cfg Config) options() ([]Option, error) {
+	var opts []Option
+	if cfg.LogID == "" {
+		return nil, configError("logID", "must not be empty")
+	}
+
+	for k, v := range cfg.Labels {
+		if err := validateLabel(k, v); err != nil {
+			return nil, configError("labels."+k, "%v", err)
+		}
+	}
+	if len(cfg.Labels) > 0 {
+		opts = append(opts, WithCommonLabels(cfg.Labels))
+	}
+
+	if cfg.Resource == "auto" && len(cfg.ResourceLabels) > 0 {
+		return nil, configError("resourceLabels", "must be empty when the resource is detected")
+	}
+	if cfg.Resource == "" && len(cfg.ResourceLabels) > 0 {
+		return nil, configError("resourceLabels", "requires a resource type")
+	}
+	if res := resourceOption(cfg.Resource, cfg.ResourceLabels); res != nil {
+		opts = append(opts, res)
+	}
+
+	if len(cfg.SeverityMapping) > 0 {
+		m := make(map[zapcore.Level]gcl.Severity, len(DefaultSeverityMapping))
+		for l, s := range DefaultSeverityMapping {
+			m[l] = s
+		}
+		for name, sev := range cfg.SeverityMapping {
+			l, err := zapcore.ParseLevel(name)
+			if err != nil {
+				return nil, configError("severityMapping."+name, "%v", err)
+			}
+			s := gcl.ParseSeverity(sev)
+			if s == gcl.Default && !strings.EqualFold(sev, gcl.Default.String()) {
+				return nil, configError("severityMapping."+name, "unrecognized severity: %q", sev)
+			}
+			m[l] = s
+		}
+		opts = append(opts, WithSeverityMapping(m))
+	}
+
+	if cfg.GCLLevel != "" {
+		l, err := zapcore.ParseLevel(cfg.GCLLevel)
+		if err != nil {
+			return nil, configError("gclLevel", "%v", err)
+		}
+		opts = append(opts, WithLevel(l))
+	}
+
+	b := cfg.Buffering
+	var lopts []gcl.LoggerOption
+	switch {
+	case b.DelayThreshold < 0:
+		return nil, configError("buffering.delayThreshold", "must not be negative")
+	case b.DelayThreshold > 0:
+		lopts = append(lopts, gcl.DelayThreshold(time.Duration(b.DelayThreshold)))
+	}
+	for _, t := range []struct {
+		key string
+		n   int
+		opt func(int) gcl.LoggerOption
+	}{
+		{"entryCountThreshold", b.EntryCountThreshold, gcl.EntryCountThreshold},
+		{"entryByteThreshold", b.EntryByteThreshold, gcl.EntryByteThreshold},
+		{"entryByteLimit", b.EntryByteLimit, gcl.EntryByteLimit},
+		{"bufferedByteLimit", b.BufferedByteLimit, gcl.BufferedByteLimit},
+	} {
+		switch {
+		case t.n < 0:
+			return nil, configError("buffering."+t.key, "must not be negative")
+		case t.n > 0:
+			lopts = append(lopts, t.opt(t.n))
+		}
+	}
+	if len(lopts) > 0 {
+		opts = append(opts, WithLoggerOptions(lopts...))
+	}
+	return opts, nil
+}
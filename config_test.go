@@ -0,0 +1,127 @@
+package zapgcl
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	gcl "cloud.google.com/go/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"gopkg.in/yaml.v3"
+)
+
+const testConfigYAML = `
+level: debug
+encoding: json
+outputPaths: [stderr]
+projectID: proj
+logID: log
+labels: {env: prod}
+resource: global
+severityMapping: {warn: NOTICE}
+gclLevel: warn
+buffering: {delayThreshold: 2s, entryCountThreshold: 500}
+`
+
+func TestConfigUnmarshal(t *testing.T) {
+	var fromYAML Config
+	if err := yaml.Unmarshal([]byte(testConfigYAML), &fromYAML); err != nil {
+		t.Fatal(err)
+	}
+	if fromYAML.Level.Level() != zapcore.DebugLevel || fromYAML.Encoding != "json" {
+		t.Errorf("zap.Config not decoded: %+v", fromYAML.Config)
+	}
+	if fromYAML.ProjectID != "proj" || fromYAML.LogID != "log" || fromYAML.Labels["env"] != "prod" ||
+		fromYAML.Buffering.DelayThreshold != Duration(2*time.Second) || fromYAML.Buffering.EntryCountThreshold != 500 {
+		t.Errorf("unexpected config %+v", fromYAML)
+	}
+
+	var fromJSON Config
+	doc := `{"level": "debug", "encoding": "json", "logID": "log", "gclLevel": "warn", "buffering": {"delayThreshold": "2s"}}`
+	if err := json.Unmarshal([]byte(doc), &fromJSON); err != nil {
+		t.Fatal(err)
+	}
+	if fromJSON.Level.Level() != zapcore.DebugLevel || fromJSON.LogID != "log" || fromJSON.GCLLevel != "warn" ||
+		fromJSON.Buffering.DelayThreshold != Duration(2*time.Second) {
+		t.Errorf("unexpected config %+v", fromJSON)
+	}
+}
+
+func TestConfigOptions(t *testing.T) {
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(testConfigYAML), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	opts, err := cfg.options()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tl := &testLogger{}
+	c, err := NewCore(nil, "", append(opts, WithGoogleCloudLogger(tl))...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Enabled(zapcore.InfoLevel) || !c.Enabled(zapcore.WarnLevel) {
+		t.Error("gclLevel not applied")
+	}
+	if c.Resource == nil || c.Resource.Type != "global" {
+		t.Errorf("unexpected resource %v", c.Resource)
+	}
+	zap.New(c).Warn("hello")
+	if len(tl.entries) != 1 || tl.entries[0].Severity != gcl.Notice || tl.entries[0].Labels["env"] != "prod" {
+		t.Errorf("unexpected entries %+v", tl.entries)
+	}
+}
+
+func TestConfigApplyEnv(t *testing.T) {
+	labels := map[string]string{"env": "prod", "team": "core"}
+	cfg := Config{LogID: "log", Labels: labels}
+	t.Setenv("ZAPGCL_LOG_ID", "other")
+	t.Setenv("ZAPGCL_LABELS", "env:dev")
+	t.Setenv("ZAPGCL_LEVEL", "warn")
+	t.Setenv("ZAPGCL_DELAY_THRESHOLD", "500ms")
+	t.Setenv("ZAPGCL_BUFFERED_BYTE_LIMIT", "1024")
+	if err := cfg.ApplyEnv(); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.LogID != "other" || cfg.Labels["env"] != "dev" || cfg.Labels["team"] != "core" ||
+		cfg.Level.Level() != zapcore.WarnLevel || cfg.Buffering.DelayThreshold != Duration(500*time.Millisecond) ||
+		cfg.Buffering.BufferedByteLimit != 1024 {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+	if labels["env"] != "prod" {
+		t.Error("the original labels were modified")
+	}
+
+	t.Setenv("ZAPGCL_ENTRY_COUNT_THRESHOLD", "many")
+	if err := cfg.ApplyEnv(); err == nil || !strings.Contains(err.Error(), "ZAPGCL_ENTRY_COUNT_THRESHOLD") {
+		t.Errorf("expected an error naming the variable, got %v", err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	for _, tc := range []struct {
+		cfg Config
+		key string
+	}{
+		{Config{}, "logID"},
+		{Config{LogID: "log", Labels: map[string]string{"": "x"}}, "labels."},
+		{Config{LogID: "log", ResourceLabels: map[string]string{"zone": "a"}}, "resourceLabels"},
+		{Config{LogID: "log", SeverityMapping: map[string]string{"loud": "ERROR"}}, "severityMapping.loud"},
+		{Config{LogID: "log", SeverityMapping: map[string]string{"warn": "LOUD"}}, "severityMapping.warn"},
+		{Config{LogID: "log", GCLLevel: "loud"}, "gclLevel"},
+		{Config{LogID: "log", Buffering: BufferingConfig{EntryByteLimit: -1}}, "buffering.entryByteLimit"},
+	} {
+		err := tc.cfg.Validate()
+		if err == nil || !strings.Contains(err.Error(), "config "+tc.key) {
+			t.Errorf("expected an error naming %s, got %v", tc.key, err)
+		}
+	}
+
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "")
+	if _, _, err := (Config{LogID: "log"}).Build(); err == nil || !strings.Contains(err.Error(), "config projectID") {
+		t.Errorf("expected an error naming projectID, got %v", err)
+	}
+}
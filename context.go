@@ -0,0 +1,76 @@
+package zapgcl
+
+import (
+	"context"
+
+	gcl "cloud.google.com/go/logging"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// ContextKey is the key of the fields built by Context. Encoders other than
+// the Core's and the gcl-json encoder skip them.
+const ContextKey = "zapgcl/context"
+
+// loggerKey is the context.Context key of the logger stored by WithLogger.
+type loggerKey struct{}
+
+// WithLogger returns a copy of ctx carrying l, which L returns.
+func WithLogger(ctx context.Context, l *zap.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// L returns the logger stored in ctx by WithLogger, or zap.L() if there is
+// none. If ctx carries a valid OpenTelemetry span context, the logger ties
+// its entries to the span (see Context).
+func L(ctx context.Context) *zap.Logger {
+	l, ok := ctx.Value(loggerKey{}).(*zap.Logger)
+	if !ok {
+		l = zap.L()
+	}
+	if trace.SpanContextFromContext(ctx).IsValid() {
+		l = l.With(Context(ctx))
+	}
+	return l
+}
+
+// Context returns a field carrying ctx. The Core sets the trace, spanId and
+// traceSampled of entries from the OpenTelemetry span context of ctx, unless
+// the TraceKey, SpanIDKey and TraceSampledKey fields are set, and so does
+// the gcl-json encoder. The field adds nothing to other encoders.
+func Context(ctx context.Context) zap.Field {
+	return zap.Field{Key: ContextKey, Type: zapcore.InlineMarshalerType, Interface: contextMarshaler{ctx}}
+}
+
+// contextMarshaler is the inline marshaler of Context fields. It adds
+// nothing to encoders other than payloadEncoders, which keep the context at
+// their root for extractSpanContext, whatever the namespace.
+type contextMarshaler struct {
+	ctx context.Context
+}
+
+func (m contextMarshaler) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	if ce, ok := enc.(interface{ setContext(context.Context) }); ok {
+		ce.setContext(m.ctx)
+	}
+	return nil
+}
+
+// extractSpanContext sets the trace fields of the entry from the span
+// context of the Context field, if any, and removes the field from the
+// payload. It must run before extractTrace, whose fields take precedence.
+func (c *Core) extractSpanContext(entry *gcl.Entry, payload map[string]interface{}) {
+	ctx, ok := payload[ContextKey].(context.Context)
+	delete(payload, ContextKey)
+	if !ok {
+		return
+	}
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return
+	}
+	entry.Trace = traceName(c.ProjectID, sc.TraceID().String())
+	entry.SpanID = sc.SpanID().String()
+	entry.TraceSampled = sc.IsSampled()
+}
@@ -0,0 +1,64 @@
+package zapgcl
+
+import (
+	"context"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+func spanContext(t *testing.T) context.Context {
+	t.Helper()
+	traceID, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanID, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	sc := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceID, SpanID: spanID, TraceFlags: trace.FlagsSampled})
+	return trace.ContextWithSpanContext(context.Background(), sc)
+}
+
+func TestContextField(t *testing.T) {
+	tl := &testLogger{}
+	l := zap.New(&Core{Logger: tl, ProjectID: "proj"})
+	ctx := spanContext(t)
+
+	l.With(zap.Namespace("ns")).Info("span", Context(ctx))
+	l.Info("explicit", Context(ctx), zap.String(TraceKey, "other"))
+	l.Info("no span", Context(context.Background()))
+
+	e := tl.entries[0]
+	if e.Trace != "projects/proj/traces/4bf92f3577b34da6a3ce929d0e0e4736" || e.SpanID != "00f067aa0ba902b7" || !e.TraceSampled {
+		t.Errorf("entry not tied to the span: %+v", e)
+	}
+	if _, ok := e.Payload.(map[string]interface{})["ns"].(map[string]interface{})[ContextKey]; ok {
+		t.Error("the context field was put in the namespace")
+	}
+	if e := tl.entries[1]; e.Trace != "projects/proj/traces/other" {
+		t.Errorf("the trace field should take precedence: %+v", e)
+	}
+	for _, e := range tl.entries {
+		if _, ok := e.Payload.(map[string]interface{})[ContextKey]; ok {
+			t.Errorf("the context field was left in the payload: %v", e.Payload)
+		}
+	}
+	if e := tl.entries[2]; e.Trace != "" || e.SpanID != "" {
+		t.Errorf("unexpected trace: %+v", e)
+	}
+}
+
+func TestContextLogger(t *testing.T) {
+	tl := &testLogger{}
+	l := zap.New(&Core{Logger: tl, ProjectID: "proj"})
+
+	if L(context.Background()) != zap.L() {
+		t.Error("expected the global logger")
+	}
+
+	ctx := WithLogger(spanContext(t), l)
+	L(ctx).Info("hello")
+	if len(tl.entries) != 1 || tl.entries[0].SpanID != "00f067aa0ba902b7" {
+		t.Errorf("unexpected entries %+v", tl.entries)
+	}
+	if L(WithLogger(context.Background(), l)) != l {
+		t.Error("expected the stored logger")
+	}
+}
@@ -0,0 +1,112 @@
+package zapgcl
+
+import (
+	"io"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+// EncoderName is the name the structured JSON encoder is registered with zap
+// under, so that a zap.Config can select it with
+//
+//	"encoding": "gcl-json"
+const EncoderName = "gcl-json"
+
+func init() {
+	err := zap.RegisterEncoder(EncoderName, func(cfg zapcore.EncoderConfig) (zapcore.Encoder, error) {
+		return NewJSONEncoder(cfg), nil
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+var bufferPool = buffer.NewPool()
+
+// jsonEncoder is a zapcore.Encoder which renders entries as JSONLogger writes
+// them, using a Core to map them. Context fields are kept in the same form as
+// a Core keeps them.
+type jsonEncoder struct {
+	*payloadEncoder
+	core       *Core
+	lineEnding string
+}
+
+// NewJSONEncoder returns a zapcore.Encoder which renders entries as the
+// structured JSON the Cloud Logging agents understand, exactly as a Core
+// configured by opts would send them to a JSONLogger. Of cfg, only the
+// LineEnding is used, since the format fixes the keys and encodings of the
+// rest.
+func NewJSONEncoder(cfg zapcore.EncoderConfig, opts ...Option) zapcore.Encoder {
+	o := newOptions(opts)
+	o.logger = NewJSONLogger(io.Discard)
+	if o.projectID == "" {
+		o.projectID = projectIDFromEnv()
+	}
+	lineEnding := cfg.LineEnding
+	if lineEnding == "" {
+		lineEnding = zapcore.DefaultLineEnding
+	}
+	return &jsonEncoder{
+		payloadEncoder: newPayloadEncoder(make(map[string]interface{})),
+		core:           newCore(nil, "", o),
+		lineEnding:     lineEnding,
+	}
+}
+
+// Clone implements zapcore.Encoder.
+func (e *jsonEncoder) Clone() zapcore.Encoder {
+	root, ns := clone(e.root, e.ns, nil)
+	pe := newPayloadEncoder(root)
+	for _, k := range ns {
+		pe.cur = pe.cur[k].(map[string]interface{})
+	}
+	pe.ns = ns
+	return &jsonEncoder{payloadEncoder: pe, core: e.core, lineEnding: e.lineEnding}
+}
+
+// AddObject implements zapcore.ObjectEncoder. An HTTPRequestKey marshaler
+// added at the root is kept as-is for the HTTPRequestExtractors, as the Core
+// keeps it (see clone).
+func (e *jsonEncoder) AddObject(key string, v zapcore.ObjectMarshaler) error {
+	if key == HTTPRequestKey && len(e.ns) == 0 {
+		e.cur[key] = v
+		return nil
+	}
+	return e.payloadEncoder.AddObject(key, v)
+}
+
+// AddReflected implements zapcore.ObjectEncoder, keeping HTTPRequestKey
+// values like AddObject does.
+func (e *jsonEncoder) AddReflected(key string, v interface{}) error {
+	if key == HTTPRequestKey && len(e.ns) == 0 {
+		e.cur[key] = v
+		return nil
+	}
+	return e.payloadEncoder.AddReflected(key, v)
+}
+
+// EncodeEntry implements zapcore.Encoder. Entries the Core would split are
+// rendered as several lines.
+func (e *jsonEncoder) EncodeEntry(ze zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	c := *e.core
+	c.fields = e.root
+	c.namespace = e.ns
+
+	// Invalid labels are dropped, as when writing to the API.
+	entry, payload, _, _ := c.entry(ze, fields)
+
+	buf := bufferPool.Get()
+	for _, ge := range c.fit(entry, payload) {
+		b, err := structuredJSON(ge)
+		if err != nil {
+			buf.Free()
+			return nil, err
+		}
+		buf.Write(b)
+		buf.AppendString(e.lineEnding)
+	}
+	return buf, nil
+}
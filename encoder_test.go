@@ -0,0 +1,129 @@
+package zapgcl
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestJSONEncoderMatchesCore(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "proj")
+
+	var buf bytes.Buffer
+	c := &Core{Logger: NewJSONLogger(&buf), ProjectID: "proj", SeverityMapping: DefaultSeverityMapping}
+	enc := NewJSONEncoder(zapcore.EncoderConfig{})
+	encCore := zapcore.NewCore(enc, zapcore.AddSync(&buf), zapcore.DebugLevel)
+
+	for _, core := range []zapcore.Core{c, encCore} {
+		l := zap.New(core).Named("svc").With(
+			zap.String(LabelPrefix+"env", "test"),
+			zap.String(TraceKey, "abc"),
+			zap.Namespace("ns"),
+			zap.String("a", "b"),
+		)
+		l.Warn("hello", zap.Duration("d", time.Second))
+		l.Info("again", zap.Int("n", 1))
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected four lines, got %q", buf.String())
+	}
+	for i := 0; i < 2; i++ {
+		var fromCore, fromEncoder map[string]interface{}
+		if err := json.Unmarshal([]byte(lines[i]), &fromCore); err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal([]byte(lines[i+2]), &fromEncoder); err != nil {
+			t.Fatal(err)
+		}
+		// The timestamps differ.
+		delete(fromCore, timestampKey)
+		delete(fromEncoder, timestampKey)
+		if diff := cmp.Diff(fromCore, fromEncoder); diff != "" {
+			t.Errorf("line %d: %s", i, diff)
+		}
+	}
+
+	var first map[string]interface{}
+	json.Unmarshal([]byte(lines[2]), &first)
+	expected := map[string]interface{}{
+		"message":                       "hello",
+		"severity":                      "WARNING",
+		"logger":                        "svc",
+		"ns":                            map[string]interface{}{"a": "b", "d": "1s"},
+		"logging.googleapis.com/trace":  "projects/proj/traces/abc",
+		"logging.googleapis.com/labels": map[string]interface{}{"env": "test"},
+	}
+	delete(first, timestampKey)
+	if diff := cmp.Diff(expected, first); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestJSONEncoderRegistered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.log")
+	cfg := zap.NewProductionConfig()
+	cfg.Encoding = EncoderName
+	cfg.OutputPaths = []string{path}
+
+	l, err := cfg.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Error("boom")
+	l.Sync()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("%v: %s", err, b)
+	}
+	if got["severity"] != "ERROR" || got["message"] != "boom" || got["stack"] == nil {
+		t.Errorf("unexpected entry %v", got)
+	}
+	if _, ok := got["logging.googleapis.com/sourceLocation"]; !ok {
+		t.Error("missing source location")
+	}
+}
+
+func TestJSONEncoderContextFields(t *testing.T) {
+	var buf bytes.Buffer
+	enc := NewJSONEncoder(zapcore.EncoderConfig{}, WithProjectID("proj"))
+	lg := zap.New(zapcore.NewCore(enc, zapcore.AddSync(&buf), zapcore.DebugLevel))
+
+	req := httptest.NewRequest("GET", "http://example.com/items", nil)
+	L(WithLogger(spanContext(t), lg)).With(zap.Namespace("ns")).Info("span")
+	lg.With(zap.Object(HTTPRequestKey, &HTTPExchange{Request: req, Status: 200})).Info("request")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected two lines, got %q", buf.String())
+	}
+	var span, request map[string]interface{}
+	json.Unmarshal([]byte(lines[0]), &span)    // nolint: errcheck
+	json.Unmarshal([]byte(lines[1]), &request) // nolint: errcheck
+	if span["logging.googleapis.com/trace"] != "projects/proj/traces/4bf92f3577b34da6a3ce929d0e0e4736" ||
+		span["logging.googleapis.com/spanId"] != "00f067aa0ba902b7" || span["logging.googleapis.com/trace_sampled"] != true {
+		t.Errorf("line not tied to the span: %v", span)
+	}
+	if _, ok := span[ContextKey]; ok {
+		t.Errorf("the context field was left in the payload: %v", span)
+	}
+	hr, ok := request["httpRequest"].(map[string]interface{})
+	if !ok || hr["requestUrl"] != "http://example.com/items" || hr["status"] != float64(200) {
+		t.Errorf("unexpected httpRequest %v", request)
+	}
+}
@@ -0,0 +1,143 @@
+package zapgcl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+
+	gcl "cloud.google.com/go/logging"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// reportedErrorEventType marks a jsonPayload as an error for Error
+	// Reporting.
+	reportedErrorEventType = "type.googleapis.com/google.devtools.clouderrorreporting.v1beta1.ReportedErrorEvent"
+
+	// FingerprintLabel is the label holding the error fingerprint added to
+	// entries shaped for Error Reporting.
+	FingerprintLabel = "error_fingerprint"
+)
+
+// ErrorReporting enables shaping Error and higher level entries so that Cloud
+// Error Reporting picks them up.
+type ErrorReporting struct {
+	// Service and Version populate the serviceContext of reported errors.
+	// Service defaults to "unknown", as Error Reporting requires one.
+	Service string
+	Version string
+}
+
+// WithErrorReporting shapes Error, DPanic, Panic and Fatal entries for Cloud
+// Error Reporting, on behalf of the given service and version.
+func WithErrorReporting(service, version string) Option {
+	return optionFunc(func(o *options) {
+		o.errorReporting = &ErrorReporting{Service: service, Version: version}
+	})
+}
+
+// numberRE matches the parts of a message that typically vary between
+// occurrences of the same error.
+var numberRE = regexp.MustCompile(`0x[0-9a-fA-F]+|[0-9]+`)
+
+// Fingerprint returns a stable identifier for errors logged with message at
+// the function of caller. Numbers in the message are ignored so that IDs,
+// counts and addresses don't split a group.
+func Fingerprint(caller zapcore.EntryCaller, message string) string {
+	location := callerFunction(caller)
+	if location == "" {
+		location = caller.File
+	}
+	sum := sha256.Sum256([]byte(location + "\x00" + numberRE.ReplaceAllString(message, "#")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// callerFunction returns the name of the caller's function, if known.
+func callerFunction(caller zapcore.EntryCaller) string {
+	if !caller.Defined {
+		return ""
+	}
+	if caller.Function != "" {
+		return caller.Function
+	}
+	if fn := runtime.FuncForPC(caller.PC); fn != nil {
+		return fn.Name()
+	}
+	return ""
+}
+
+// goroutineTrace renders the entry's stack, or failing that its caller, in
+// the format of a Go panic, which is what Error Reporting parses.
+func goroutineTrace(ze zapcore.Entry) string {
+	var b strings.Builder
+	b.WriteString("goroutine 1 [running]:\n")
+	if ze.Stack != "" {
+		// zap writes "function\n\tfile:line" pairs, without the parentheses
+		// a Go traceback has after the function name.
+		for _, line := range strings.Split(ze.Stack, "\n") {
+			if line == "" {
+				continue
+			}
+			if !strings.HasPrefix(line, "\t") {
+				line += "()"
+			}
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+		return b.String()
+	}
+	if fn := callerFunction(ze.Caller); fn != "" {
+		b.WriteString(fn + "()\n\t" + ze.Caller.File + ":" + strconv.Itoa(ze.Caller.Line) + "\n")
+		return b.String()
+	}
+	return ""
+}
+
+// reportError shapes an entry for Error Reporting. Fields which are already
+// set in the payload, for example by zapdriver's ErrorReport and
+// ServiceContext helpers, are left alone.
+func (c *Core) reportError(entry *gcl.Entry, ze zapcore.Entry, payload map[string]interface{}) {
+	if c.ErrorReporting == nil || ze.Level < zapcore.ErrorLevel {
+		return
+	}
+
+	payload["@type"] = reportedErrorEventType
+
+	if _, ok := payload["serviceContext"]; !ok {
+		service := c.ErrorReporting.Service
+		if service == "" {
+			service = "unknown"
+		}
+		sc := map[string]interface{}{"service": service}
+		if c.ErrorReporting.Version != "" {
+			sc["version"] = c.ErrorReporting.Version
+		}
+		payload["serviceContext"] = sc
+	}
+
+	if _, ok := payload["context"]; !ok && ze.Caller.Defined {
+		payload["context"] = map[string]interface{}{
+			"reportLocation": map[string]interface{}{
+				"filePath":     ze.Caller.File,
+				"lineNumber":   ze.Caller.Line,
+				"functionName": callerFunction(ze.Caller),
+			},
+		}
+	}
+
+	// Messages which carry their own trace, such as those of GinRecovery,
+	// are fingerprinted without it.
+	message, _, traced := strings.Cut(ze.Message, "\n\ngoroutine ")
+	if trace := goroutineTrace(ze); trace != "" && !traced {
+		payload["message"] = ze.Message + "\n\n" + trace
+		delete(payload, "stack")
+	}
+
+	if entry.Labels == nil {
+		entry.Labels = make(map[string]string)
+	}
+	entry.Labels[FingerprintLabel] = Fingerprint(ze.Caller, message)
+}
@@ -0,0 +1,353 @@
+package zapgcl
+
+import (
+	"context"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultCodeLevels is the default mapping of gRPC status codes to the
+// levels calls are logged at by the gRPC interceptors. Client errors are
+// warnings and server errors are errors.
+var DefaultCodeLevels = map[codes.Code]zapcore.Level{
+	codes.OK:                 zapcore.InfoLevel,
+	codes.Canceled:           zapcore.InfoLevel,
+	codes.InvalidArgument:    zapcore.WarnLevel,
+	codes.NotFound:           zapcore.WarnLevel,
+	codes.AlreadyExists:      zapcore.WarnLevel,
+	codes.PermissionDenied:   zapcore.WarnLevel,
+	codes.Unauthenticated:    zapcore.WarnLevel,
+	codes.ResourceExhausted:  zapcore.WarnLevel,
+	codes.FailedPrecondition: zapcore.WarnLevel,
+	codes.Aborted:            zapcore.WarnLevel,
+	codes.OutOfRange:         zapcore.WarnLevel,
+	codes.Unknown:            zapcore.ErrorLevel,
+	codes.DeadlineExceeded:   zapcore.ErrorLevel,
+	codes.Unimplemented:      zapcore.ErrorLevel,
+	codes.Internal:           zapcore.ErrorLevel,
+	codes.Unavailable:        zapcore.ErrorLevel,
+	codes.DataLoss:           zapcore.ErrorLevel,
+}
+
+// WithCodeLevels sets the levels the gRPC interceptors log calls at, by
+// status code. Codes which aren't in m are logged at ErrorLevel. The default
+// is DefaultCodeLevels.
+func WithCodeLevels(m map[codes.Code]zapcore.Level) MiddlewareOption {
+	return middlewareOptionFunc(func(o *middlewareOptions) {
+		o.codeLevels = m
+	})
+}
+
+func (o *middlewareOptions) codeLevel(code codes.Code) zapcore.Level {
+	m := o.codeLevels
+	if m == nil {
+		m = DefaultCodeLevels
+	}
+	if l, ok := m[code]; ok {
+		return l
+	}
+	return zapcore.ErrorLevel
+}
+
+// traceIncoming returns the trace context of an incoming call, taken from
+// its traceparent, grpc-trace-bin or x-cloud-trace-context metadata, in
+// that order. If there is none, a new, unsampled trace is started.
+func traceIncoming(ctx context.Context) TraceContext {
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, v := range md.Get(TraceparentHeader) {
+		if tc, ok := ParseTraceparent(v); ok {
+			return tc
+		}
+	}
+	for _, v := range md.Get(GRPCTraceBinHeader) {
+		if tc, ok := ParseGRPCTraceBin([]byte(v)); ok {
+			return tc
+		}
+	}
+	for _, v := range md.Get(CloudTraceContextHeader) {
+		if tc, ok := ParseCloudTraceContext(v); ok {
+			return tc
+		}
+	}
+	return NewTraceContext()
+}
+
+// serverCall returns the context and logger of an incoming call: a child of
+// logger carrying the trace fields, also stored in the context with the
+// trace context.
+func serverCall(ctx context.Context, logger *zap.Logger) (context.Context, *zap.Logger) {
+	tc := traceIncoming(ctx)
+	logger = logger.With(tc.Fields()...)
+	return withTraceContext(WithLogger(ctx, logger), tc), logger
+}
+
+// clientCall returns the context of an outgoing call, with the traceparent
+// and grpc-trace-bin metadata of its trace context, if any, and the logger
+// the call is logged with.
+func clientCall(ctx context.Context, logger *zap.Logger) (context.Context, *zap.Logger) {
+	tc, ok := TraceContextFromContext(ctx)
+	if !ok {
+		return ctx, logger
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx,
+		TraceparentHeader, tc.Traceparent(),
+		GRPCTraceBinHeader, string(tc.GRPCTraceBin()))
+	return ctx, logger.With(tc.Fields()...)
+}
+
+// messageSize returns the encoded size of m, or zero if it isn't a protocol
+// buffer message.
+func messageSize(m interface{}) int64 {
+	if pm, ok := m.(proto.Message); ok {
+		return int64(proto.Size(pm))
+	}
+	return 0
+}
+
+// callStats are the sizes and counts of the messages of a call.
+type callStats struct {
+	sent, received           atomic.Int64
+	sentBytes, receivedBytes atomic.Int64
+}
+
+func (s *callStats) send(m interface{}) {
+	s.sent.Add(1)
+	s.sentBytes.Add(messageSize(m))
+}
+
+func (s *callStats) receive(m interface{}) {
+	s.received.Add(1)
+	s.receivedBytes.Add(messageSize(m))
+}
+
+// logCall logs a finished call. The request is what the server received
+// and the client sent.
+func (o *middlewareOptions) logCall(ctx context.Context, logger *zap.Logger, side, fullMethod string, start time.Time, err error, requests, requestBytes, responses, responseBytes int64) {
+	if o.skipPaths[fullMethod] {
+		return
+	}
+	code := status.Code(err)
+	ce := logger.Check(o.codeLevel(code), fullMethod)
+	if ce == nil {
+		return
+	}
+	service, method := path.Split(strings.TrimPrefix(fullMethod, "/"))
+	fields := []zap.Field{
+		zap.String("grpc.side", side),
+		zap.String("grpc.service", strings.TrimSuffix(service, "/")),
+		zap.String("grpc.method", method),
+		zap.String("grpc.code", code.String()),
+		zap.Duration("grpc.duration", time.Since(start)),
+		zap.Int64("grpc.request.count", requests),
+		zap.Int64("grpc.request.size", requestBytes),
+		zap.Int64("grpc.response.count", responses),
+		zap.Int64("grpc.response.size", responseBytes),
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		fields = append(fields, zap.String("peer.address", p.Addr.String()))
+	}
+	if err != nil {
+		fields = append(fields, zap.Error(err))
+	}
+	ce.Write(fields...)
+}
+
+// UnaryServerInterceptor returns a gRPC interceptor which logs unary calls
+// with their method, peer, duration, status code and message sizes, at the
+// level of their status code (see WithCodeLevels).
+//
+// The trace context is taken from the traceparent, grpc-trace-bin or
+// x-cloud-trace-context metadata, or started, and the call is logged by a
+// child of logger tied to it, which handlers get with L from their context.
+func UnaryServerInterceptor(logger *zap.Logger, opts ...MiddlewareOption) grpc.UnaryServerInterceptor {
+	o := newMiddlewareOptions(opts)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		ctx, l := serverCall(ctx, logger)
+		resp, err := handler(ctx, req)
+		var responses int64
+		if err == nil {
+			responses = 1
+		}
+		o.logCall(ctx, l, "server", info.FullMethod, start, err, 1, messageSize(req), responses, messageSize(resp))
+		return resp, err
+	}
+}
+
+// StreamServerInterceptor is the streaming counterpart of
+// UnaryServerInterceptor. Message counts and sizes are totals.
+func StreamServerInterceptor(logger *zap.Logger, opts ...MiddlewareOption) grpc.StreamServerInterceptor {
+	o := newMiddlewareOptions(opts)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		start := time.Now()
+		ctx, l := serverCall(ss.Context(), logger)
+		ls := &loggingServerStream{ServerStream: ss, ctx: ctx}
+		err := handler(srv, ls)
+		s := &ls.stats
+		o.logCall(ctx, l, "server", info.FullMethod, start, err,
+			s.received.Load(), s.receivedBytes.Load(), s.sent.Load(), s.sentBytes.Load())
+		return err
+	}
+}
+
+// loggingServerStream counts the messages of a stream and carries the
+// context of the call.
+type loggingServerStream struct {
+	grpc.ServerStream
+	ctx   context.Context
+	stats callStats
+}
+
+func (s *loggingServerStream) Context() context.Context {
+	return s.ctx
+}
+
+func (s *loggingServerStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		s.stats.send(m)
+	}
+	return err
+}
+
+func (s *loggingServerStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		s.stats.receive(m)
+	}
+	return err
+}
+
+// UnaryClientInterceptor returns a gRPC interceptor which logs unary calls
+// like UnaryServerInterceptor does. The trace context of the call's context
+// (see TraceContextFromContext) is sent as traceparent and grpc-trace-bin
+// metadata, and the call is logged tied to it.
+func UnaryClientInterceptor(logger *zap.Logger, opts ...MiddlewareOption) grpc.UnaryClientInterceptor {
+	o := newMiddlewareOptions(opts)
+	return func(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, callOpts ...grpc.CallOption) error {
+		start := time.Now()
+		ctx, l := clientCall(ctx, logger)
+		var p peer.Peer
+		err := invoker(ctx, method, req, reply, cc, append(callOpts, grpc.Peer(&p))...)
+		var responses, responseBytes int64
+		if err == nil {
+			responses, responseBytes = 1, messageSize(reply)
+		}
+		o.logCall(peer.NewContext(ctx, &p), l, "client", method, start, err, 1, messageSize(req), responses, responseBytes)
+		return err
+	}
+}
+
+// StreamClientInterceptor is the streaming counterpart of
+// UnaryClientInterceptor. A stream is logged once, when it ends: when
+// RecvMsg returns an error, io.EOF meaning success, or returns the response
+// of a stream without server streaming; when SendMsg or CloseSend fail; or
+// when the context of the call is done, in which case the peer address
+// isn't logged. Streams which are neither drained nor cancelled are never
+// logged, just as grpc never releases them.
+func StreamClientInterceptor(logger *zap.Logger, opts ...MiddlewareOption) grpc.StreamClientInterceptor {
+	o := newMiddlewareOptions(opts)
+	return func(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, callOpts ...grpc.CallOption) (grpc.ClientStream, error) {
+		start := time.Now()
+		ctx, l := clientCall(ctx, logger)
+		p := &peer.Peer{}
+		cs, err := streamer(ctx, desc, cc, method, append(callOpts, grpc.Peer(p))...)
+		lcs := &loggingClientStream{serverStreams: desc.ServerStreams}
+		lcs.done = func(err error, ended bool) {
+			// The peer is set by grpc once the stream ends, which may
+			// race with the context being done.
+			logCtx := ctx
+			if ended {
+				logCtx = peer.NewContext(ctx, p)
+			}
+			s := &lcs.stats
+			o.logCall(logCtx, l, "client", method, start, err,
+				s.sent.Load(), s.sentBytes.Load(), s.received.Load(), s.receivedBytes.Load())
+		}
+		if err != nil {
+			lcs.finish(err, true)
+			return nil, err
+		}
+		lcs.ClientStream = cs
+		lcs.stop = context.AfterFunc(ctx, func() {
+			lcs.finish(status.FromContextError(ctx.Err()).Err(), false)
+		})
+		return lcs, nil
+	}
+}
+
+// loggingClientStream counts the messages of a stream and logs it once.
+type loggingClientStream struct {
+	grpc.ClientStream
+	serverStreams bool
+	stats         callStats
+	once          sync.Once
+	done          func(err error, ended bool)
+
+	// stop stops the logging of the stream when its context is done.
+	// It's only called by the methods of the stream, which run after it's
+	// set.
+	stop func() bool
+}
+
+// finish logs the stream once, with its peer if the caller has seen it end.
+func (s *loggingClientStream) finish(err error, ended bool) {
+	s.once.Do(func() { s.done(err, ended) })
+}
+
+// end logs the stream, which the caller has seen end, and stops waiting for
+// its context.
+func (s *loggingClientStream) end(err error) {
+	s.stop()
+	s.finish(err, true)
+}
+
+func (s *loggingClientStream) SendMsg(m interface{}) error {
+	err := s.ClientStream.SendMsg(m)
+	switch {
+	case err == nil:
+		s.stats.send(m)
+	case err != io.EOF:
+		// io.EOF means the stream has ended, with a status RecvMsg returns.
+		s.end(err)
+	}
+	return err
+}
+
+func (s *loggingClientStream) CloseSend() error {
+	err := s.ClientStream.CloseSend()
+	if err != nil {
+		s.end(err)
+	}
+	return err
+}
+
+func (s *loggingClientStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	switch {
+	case err == nil:
+		s.stats.receive(m)
+		if !s.serverStreams {
+			// grpc has ended the stream after its single response.
+			s.end(nil)
+		}
+	case err == io.EOF:
+		s.end(nil)
+	default:
+		s.end(err)
+	}
+	return err
+}
@@ -0,0 +1,277 @@
+package zapgcl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	gcl "cloud.google.com/go/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// echoService echoes strings, failing with InvalidArgument on "fail", and
+// counts the strings of Collect streams. Its unary handler logs with the
+// logger of its context.
+var echoService = grpc.ServiceDesc{
+	ServiceName: "test.Echo",
+	HandlerType: (*interface{})(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Echo",
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := &structpb.Value{}
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				L(ctx).Info("echo")
+				if req.(*structpb.Value).GetStringValue() == "fail" {
+					return nil, status.Error(codes.InvalidArgument, "fail")
+				}
+				return req, nil
+			}
+			return interceptor(ctx, in, &grpc.UnaryServerInfo{FullMethod: "/test.Echo/Echo"}, handler)
+		},
+	}},
+	Streams: []grpc.StreamDesc{{
+		StreamName:    "Chat",
+		ServerStreams: true,
+		ClientStreams: true,
+		Handler: func(srv interface{}, ss grpc.ServerStream) error {
+			for {
+				in := &structpb.Value{}
+				if err := ss.RecvMsg(in); err == io.EOF {
+					return nil
+				} else if err != nil {
+					return err
+				}
+				if err := ss.SendMsg(in); err != nil {
+					return err
+				}
+			}
+		},
+	}, {
+		StreamName:    "Collect",
+		ClientStreams: true,
+		Handler: func(srv interface{}, ss grpc.ServerStream) error {
+			var n float64
+			for {
+				if err := ss.RecvMsg(&structpb.Value{}); err == io.EOF {
+					return ss.SendMsg(structpb.NewNumberValue(n))
+				} else if err != nil {
+					return err
+				}
+				n++
+			}
+		},
+	}},
+}
+
+// serveEcho starts the echo service with the server interceptors and
+// returns a client connection with the client interceptors.
+func serveEcho(t *testing.T, server, client *zap.Logger, opts ...MiddlewareOption) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(UnaryServerInterceptor(server, opts...)),
+		grpc.StreamInterceptor(StreamServerInterceptor(server, opts...)),
+	)
+	s.RegisterService(&echoService, nil)
+	go s.Serve(lis) // nolint: errcheck
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithUnaryInterceptor(UnaryClientInterceptor(client, opts...)),
+		grpc.WithStreamInterceptor(StreamClientInterceptor(client, opts...)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func testGRPCLoggers() (*zap.Logger, *testLogger, *zap.Logger, *testLogger) {
+	st, ct := &testLogger{}, &testLogger{}
+	newLogger := func(tl *testLogger) *zap.Logger {
+		return zap.New(&Core{Logger: tl, ProjectID: "proj", SeverityMapping: DefaultSeverityMapping})
+	}
+	return newLogger(st), st, newLogger(ct), ct
+}
+
+func TestGRPCUnaryInterceptors(t *testing.T) {
+	server, st, client, ct := testGRPCLoggers()
+	conn := serveEcho(t, server, client)
+
+	tc, _ := ParseTraceparent(testTraceparent)
+	ctx := withTraceContext(context.Background(), tc)
+	out := &structpb.Value{}
+	if err := conn.Invoke(ctx, "/test.Echo/Echo", structpb.NewStringValue("hello"), out); err != nil {
+		t.Fatal(err)
+	}
+	err := conn.Invoke(ctx, "/test.Echo/Echo", structpb.NewStringValue("fail"), out)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	// The handler's entry, then the call's, for each call.
+	if len(st.entries) != 4 || len(ct.entries) != 2 {
+		t.Fatalf("unexpected entries %+v and %+v", st.entries, ct.entries)
+	}
+	for _, e := range append(st.entries, ct.entries...) {
+		if e.Trace != "projects/proj/traces/"+tc.TraceID || !e.TraceSampled {
+			t.Errorf("entry not tied to the trace: %+v", e)
+		}
+	}
+
+	ok, failed := st.entries[1], st.entries[3]
+	payload := ok.Payload.(map[string]interface{})
+	expected := map[string]interface{}{
+		"grpc.side": "server", "grpc.service": "test.Echo", "grpc.method": "Echo", "grpc.code": "OK",
+		"grpc.request.count": int64(1), "grpc.request.size": int64(7),
+		"grpc.response.count": int64(1), "grpc.response.size": int64(7),
+		"peer.address": "bufconn",
+	}
+	for k, v := range expected {
+		if payload[k] != v {
+			t.Errorf("%s: expected %v, got %v", k, v, payload[k])
+		}
+	}
+	if ok.Severity != gcl.Info || failed.Severity != gcl.Warning || ct.entries[1].Severity != gcl.Warning {
+		t.Errorf("unexpected severities %v, %v and %v", ok.Severity, failed.Severity, ct.entries[1].Severity)
+	}
+	if p := ct.entries[0].Payload.(map[string]interface{}); p["grpc.side"] != "client" || p["grpc.response.size"] != int64(7) {
+		t.Errorf("unexpected client entry %v", p)
+	}
+}
+
+func TestGRPCStreamInterceptors(t *testing.T) {
+	server, st, client, ct := testGRPCLoggers()
+	conn := serveEcho(t, server, client, WithCodeLevels(map[codes.Code]zapcore.Level{codes.OK: zapcore.WarnLevel}))
+
+	ctx := metadata.AppendToOutgoingContext(context.Background(), CloudTraceContextHeader, "105445aa7843bc8bf206b12000100000/1;o=1")
+	cs, err := conn.NewStream(ctx, &echoService.Streams[0], "/test.Echo/Chat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"a", "b"} {
+		if err := cs.SendMsg(structpb.NewStringValue(s)); err != nil {
+			t.Fatal(err)
+		}
+		if err := cs.RecvMsg(&structpb.Value{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := cs.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.RecvMsg(&structpb.Value{}); !errors.Is(err, io.EOF) {
+		t.Fatalf("unexpected error %v", err)
+	}
+	conn.Close()
+
+	if len(ct.entries) != 1 {
+		t.Fatalf("unexpected client entries %+v", ct.entries)
+	}
+	if e := ct.entries[0]; e.Severity != gcl.Warning || e.Payload.(map[string]interface{})["grpc.request.count"] != int64(2) {
+		t.Errorf("unexpected client entry %+v", e)
+	}
+
+	// The server logs once its handler has returned, which may be after the
+	// client has seen the end of the stream.
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		st.mu.Lock()
+		n := len(st.entries)
+		st.mu.Unlock()
+		if n > 0 {
+			break
+		}
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if len(st.entries) != 1 {
+		t.Fatalf("unexpected server entries %+v", st.entries)
+	}
+	e := st.entries[0]
+	if e.Trace != "projects/proj/traces/105445aa7843bc8bf206b12000100000" || e.Payload.(map[string]interface{})["grpc.response.count"] != int64(2) {
+		t.Errorf("unexpected server entry %+v", e)
+	}
+}
+
+func TestGRPCClientStreamEnds(t *testing.T) {
+	server, _, client, ct := testGRPCLoggers()
+	conn := serveEcho(t, server, client)
+
+	// A client-streaming call ends with its response, not with io.EOF.
+	cs, err := conn.NewStream(context.Background(), &echoService.Streams[1], "/test.Echo/Collect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.SendMsg(structpb.NewStringValue("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := cs.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	out := &structpb.Value{}
+	if err := cs.RecvMsg(out); err != nil || out.GetNumberValue() != 1 {
+		t.Fatalf("unexpected response %v, %v", out, err)
+	}
+	if len(ct.entries) != 1 {
+		t.Fatalf("expected the call to be logged, got %+v", ct.entries)
+	}
+	if p := ct.entries[0].Payload.(map[string]interface{}); p["grpc.code"] != "OK" || p["grpc.response.count"] != int64(1) {
+		t.Errorf("unexpected entry %v", p)
+	}
+
+	// A cancelled stream is logged without being drained.
+	ctx, cancel := context.WithCancel(context.Background())
+	if _, err := conn.NewStream(ctx, &echoService.Streams[0], "/test.Echo/Chat"); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		ct.mu.Lock()
+		n := len(ct.entries)
+		ct.mu.Unlock()
+		if n > 1 {
+			break
+		}
+	}
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+	if len(ct.entries) != 2 {
+		t.Fatalf("expected the cancelled stream to be logged, got %+v", ct.entries)
+	}
+	if p := ct.entries[1].Payload.(map[string]interface{}); p["grpc.code"] != "Canceled" || p["grpc.method"] != "Chat" {
+		t.Errorf("unexpected entry %v", p)
+	}
+}
+
+func TestGRPCTraceBin(t *testing.T) {
+	tc, _ := ParseTraceparent(testTraceparent)
+	b := tc.GRPCTraceBin()
+	if len(b) != 29 {
+		t.Fatalf("unexpected length %d", len(b))
+	}
+	if got, ok := ParseGRPCTraceBin(b); !ok || got != tc {
+		t.Errorf("got %+v, %v", got, ok)
+	}
+	if _, ok := ParseGRPCTraceBin(b[:10]); ok {
+		t.Error("expected a short value to be rejected")
+	}
+}
@@ -0,0 +1,139 @@
+package zapgcl
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/felixge/httpsnoop"
+	gologger "github.com/govargo/go-logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc/codes"
+)
+
+// A MiddlewareOption configures HTTPMiddleware, HTTPRecovery, GinRecovery
+// and the gRPC interceptors.
+type MiddlewareOption interface {
+	applyMiddleware(*middlewareOptions)
+}
+
+type middlewareOptionFunc func(*middlewareOptions)
+
+func (f middlewareOptionFunc) applyMiddleware(o *middlewareOptions) { f(o) }
+
+type middlewareOptions struct {
+	skipPaths       map[string]bool
+	stack           bool
+	codeLevels      map[codes.Code]zapcore.Level
+	maskedHeaders   []string
+	maskHeadersSet  bool
+	recoveryHandler RecoveryHandler
+}
+
+func newMiddlewareOptions(opts []MiddlewareOption) *middlewareOptions {
+	o := &middlewareOptions{skipPaths: make(map[string]bool)}
+	for _, opt := range opts {
+		opt.applyMiddleware(o)
+	}
+	return o
+}
+
+// WithSkipPaths stops HTTPMiddleware from logging requests for the given URL
+// paths, and the gRPC interceptors from logging calls of the given full
+// method names, such as health checks. Their trace context is still
+// propagated.
+func WithSkipPaths(paths ...string) MiddlewareOption {
+	return middlewareOptionFunc(func(o *middlewareOptions) {
+		for _, p := range paths {
+			o.skipPaths[p] = true
+		}
+	})
+}
+
+// WithStack makes HTTPRecovery and GinRecovery log the stack of the
+// panicking goroutine.
+func WithStack(stack bool) MiddlewareOption {
+	return middlewareOptionFunc(func(o *middlewareOptions) {
+		o.stack = stack
+	})
+}
+
+// traceRequest returns a child of logger carrying the trace fields of r, and
+// a shallow copy of r whose context holds that logger (see WithLogger) and
+// the trace context. The trace context is echoed in a traceparent header in
+// h.
+func traceRequest(logger *zap.Logger, h http.Header, r *http.Request) (*zap.Logger, *http.Request) {
+	tc := TraceContextFromHeader(r.Header)
+	logger = logger.With(tc.Fields()...)
+	h.Set(TraceparentHeader, tc.Traceparent())
+	ctx := withTraceContext(WithLogger(r.Context(), logger), tc)
+	return logger, r.WithContext(ctx)
+}
+
+// logRequest writes the entry ZapGin and HTTPMiddleware log for a request
+// served without errors.
+func logRequest(logger *zap.Logger, r *http.Request, path string, status int, size int64, latency time.Duration) {
+	res := &http.Response{StatusCode: status}
+	httpPayload := gologger.NewHTTP(r, res)
+	httpPayload.Latency = latency.String()
+	httpPayload.ResponseSize = strconv.FormatInt(size, 10)
+	logger.Info(path, gologger.HTTP(httpPayload))
+}
+
+// HTTPMiddleware returns net/http middleware which logs requests like ZapGin
+// does: each is logged by a child of logger tied to the request's trace
+// context, which handlers get with L from the request's context, and the
+// trace context is echoed in a traceparent response header.
+//
+// The status, response size and latency are captured with httpsnoop, so the
+// http.ResponseWriter keeps implementing http.Flusher, http.Hijacker and the
+// other optional interfaces of the one it wraps.
+func HTTPMiddleware(logger *zap.Logger, opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	o := newMiddlewareOptions(opts)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path := r.URL.Path
+			logger, r := traceRequest(logger, w.Header(), r)
+			m := httpsnoop.CaptureMetrics(next, w, r)
+			if !o.skipPaths[path] {
+				logRequest(logger, r, path, m.Code, m.Written, m.Duration)
+			}
+		})
+	}
+}
+
+// HTTPRecovery returns net/http middleware which recovers from panics with
+// any value, like GinRecovery does, logging them with the request's logger
+// (see L) and responding with the RecoveryHandler (see WithRecoveryHandler).
+// Panics with http.ErrAbortHandler, which abort the response on purpose, are
+// passed on, and panics over a broken connection are logged without a
+// response.
+//
+// Wrapped by HTTPMiddleware, as in HTTPMiddleware(l)(HTTPRecovery(l)(h)), the
+// panic is tied to the request's trace and the 500 response is logged too.
+func HTTPRecovery(logger *zap.Logger, opts ...MiddlewareOption) func(http.Handler) http.Handler {
+	o := newMiddlewareOptions(opts)
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+
+				l := logger
+				if rl, ok := r.Context().Value(loggerKey{}).(*zap.Logger); ok {
+					l = rl
+				}
+				if err, broken := o.logPanic(l, r, v); !broken {
+					o.recover(w, r, err)
+				}
+			}()
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package zapgcl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gcl "cloud.google.com/go/logging"
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"go.uber.org/zap"
+)
+
+const testTraceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func newTraceRequest() *http.Request {
+	req := httptest.NewRequest("POST", "http://example.com/items?id=1", nil)
+	req.Header.Set(TraceparentHeader, testTraceparent)
+	req.Header.Set("User-Agent", "test")
+	return req
+}
+
+func TestHTTPMiddlewareMatchesGin(t *testing.T) {
+	handle := func(l *zap.Logger, w http.ResponseWriter) {
+		l.Info("handler")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created")) // nolint: errcheck
+	}
+
+	viaHTTP := &testLogger{}
+	logger := zap.New(&Core{Logger: viaHTTP, ProjectID: "proj", SeverityMapping: DefaultSeverityMapping})
+	var flushable bool
+	h := HTTPMiddleware(logger)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, flushable = w.(http.Flusher)
+		handle(L(r.Context()), w)
+	}))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newTraceRequest())
+	if !flushable {
+		t.Error("the ResponseWriter lost http.Flusher")
+	}
+	if got := w.Header().Get(TraceparentHeader); got != testTraceparent {
+		t.Errorf("unexpected traceparent %q", got)
+	}
+
+	gin.SetMode(gin.TestMode)
+	viaGin := &testLogger{}
+	logger = zap.New(&Core{Logger: viaGin, ProjectID: "proj", SeverityMapping: DefaultSeverityMapping})
+	r := gin.New()
+	r.Use(ZapGin(logger, true))
+	r.POST("/items", func(c *gin.Context) { handle(FromGin(c), c.Writer) })
+	r.ServeHTTP(httptest.NewRecorder(), newTraceRequest())
+
+	if len(viaHTTP.entries) != 2 {
+		t.Fatalf("expected two entries, got %d", len(viaHTTP.entries))
+	}
+	if e := viaHTTP.entries[1]; e.HTTPRequest == nil || e.HTTPRequest.Status != http.StatusCreated || e.HTTPRequest.ResponseSize != 7 {
+		t.Errorf("unexpected HTTP request %+v", e.HTTPRequest)
+	}
+	ignore := []cmp.Option{
+		cmpopts.IgnoreFields(gcl.Entry{}, "Timestamp"),
+		cmpopts.IgnoreFields(gcl.HTTPRequest{}, "Request", "Latency"),
+	}
+	if diff := cmp.Diff(viaGin.entries, viaHTTP.entries, ignore...); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestHTTPRecovery(t *testing.T) {
+	tl := &testLogger{}
+	logger := zap.New(&Core{Logger: tl, ProjectID: "proj", SeverityMapping: DefaultSeverityMapping})
+	panicking := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic(http.ErrBodyNotAllowed)
+	})
+	h := HTTPMiddleware(logger, WithSkipPaths("/healthz"))(HTTPRecovery(logger, WithStack(true))(panicking))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, newTraceRequest())
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("unexpected status %d", w.Code)
+	}
+	if len(tl.entries) != 2 {
+		t.Fatalf("expected two entries, got %d", len(tl.entries))
+	}
+	e := tl.entries[0]
+	payload := e.Payload.(map[string]interface{})
+	if e.Severity != gcl.Error || payload["error"] != http.ErrBodyNotAllowed.Error() || !strings.Contains(payload["message"].(string), "\n\ngoroutine ") || e.SpanID != "00f067aa0ba902b7" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if tl.entries[1].HTTPRequest == nil || tl.entries[1].HTTPRequest.Status != http.StatusInternalServerError {
+		t.Errorf("unexpected access log %+v", tl.entries[1])
+	}
+
+	tl.entries = nil
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthz", nil))
+	if len(tl.entries) != 1 {
+		t.Errorf("expected only the panic to be logged, got %+v", tl.entries)
+	}
+
+	defer func() {
+		if recover() != http.ErrAbortHandler {
+			t.Error("expected http.ErrAbortHandler to be passed on")
+		}
+	}()
+	HTTPRecovery(logger)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic(http.ErrAbortHandler)
+	})).ServeHTTP(httptest.NewRecorder(), newTraceRequest())
+}
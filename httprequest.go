@@ -0,0 +1,204 @@
+package zapgcl
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	gcl "cloud.google.com/go/logging"
+	"github.com/blendle/zapdriver"
+	gologger "github.com/govargo/go-logger"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// HTTPRequestKey is the payload field key to use to set the httpRequest
+	// field in the LogEntry object.
+	HTTPRequestKey = "httpRequest"
+)
+
+// An HTTPRequestExtractor converts the value of an HTTPRequestKey payload
+// field into a gcl.HTTPRequest. It reports false if it doesn't recognise the
+// value, in which case the next extractor is tried.
+//
+// Extractors must return a gcl.HTTPRequest with a non-nil Request whose URL
+// is also non-nil.
+type HTTPRequestExtractor func(v interface{}) (*gcl.HTTPRequest, bool)
+
+// DefaultHTTPRequestExtractors understands the HTTP payloads of zapdriver and
+// go-logger, *gcl.HTTPRequest, *HTTPExchange (see HTTP) and a bare
+// *http.Request. Values of any other type are left in the payload.
+var DefaultHTTPRequestExtractors = []HTTPRequestExtractor{
+	ExtractZapdriverHTTPRequest,
+	ExtractGoLoggerHTTPRequest,
+	ExtractGCLHTTPRequest,
+	ExtractHTTPExchange,
+	ExtractStdHTTPRequest,
+}
+
+// HTTPExchange is an *http.Request together with the details of the response
+// sent for it.
+type HTTPExchange struct {
+	Request      *http.Request
+	Status       int
+	ResponseSize int64
+	Latency      time.Duration
+}
+
+// HTTP returns a field which sets the httpRequest field of the LogEntry from
+// req and the status, size and latency of its response.
+func HTTP(req *http.Request, status int, responseSize int64, latency time.Duration) zap.Field {
+	return zap.Object(HTTPRequestKey, &HTTPExchange{
+		Request:      req,
+		Status:       status,
+		ResponseSize: responseSize,
+		Latency:      latency,
+	})
+}
+
+// MarshalLogObject implements zapcore.ObjectMarshaler, using the same keys as
+// the LogEntry's httpRequest object.
+func (x *HTTPExchange) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	if r := x.Request; r != nil {
+		enc.AddString("requestMethod", r.Method)
+		if r.URL != nil {
+			enc.AddString("requestUrl", r.URL.String())
+		}
+		if r.ContentLength > 0 {
+			enc.AddString("requestSize", strconv.FormatInt(r.ContentLength, 10))
+		}
+		enc.AddString("userAgent", r.UserAgent())
+		enc.AddString("remoteIp", r.RemoteAddr)
+		enc.AddString("referer", r.Referer())
+		enc.AddString("protocol", r.Proto)
+	}
+	enc.AddInt("status", x.Status)
+	enc.AddString("responseSize", strconv.FormatInt(x.ResponseSize, 10))
+	enc.AddString("latency", x.Latency.String())
+	return nil
+}
+
+// ExtractZapdriverHTTPRequest extracts a *zapdriver.HTTPPayload.
+func ExtractZapdriverHTTPRequest(v interface{}) (*gcl.HTTPRequest, bool) {
+	p, ok := v.(*zapdriver.HTTPPayload)
+	if !ok || p == nil {
+		return nil, false
+	}
+	return fromHTTPPayload(p), true
+}
+
+// ExtractGoLoggerHTTPRequest extracts a *gologger.HTTPPayload.
+func ExtractGoLoggerHTTPRequest(v interface{}) (*gcl.HTTPRequest, bool) {
+	p, ok := v.(*gologger.HTTPPayload)
+	if !ok || p == nil {
+		return nil, false
+	}
+	// The two libraries' payloads are field-for-field identical.
+	zp := zapdriver.HTTPPayload(*p)
+	return fromHTTPPayload(&zp), true
+}
+
+// ExtractGCLHTTPRequest extracts a *gcl.HTTPRequest.
+func ExtractGCLHTTPRequest(v interface{}) (*gcl.HTTPRequest, bool) {
+	r, ok := v.(*gcl.HTTPRequest)
+	if !ok || r == nil || r.Request == nil || r.Request.URL == nil {
+		return nil, false
+	}
+	return r, true
+}
+
+// ExtractHTTPExchange extracts an *HTTPExchange, as logged by HTTP.
+func ExtractHTTPExchange(v interface{}) (*gcl.HTTPRequest, bool) {
+	x, ok := v.(*HTTPExchange)
+	if !ok || x == nil || x.Request == nil || x.Request.URL == nil {
+		return nil, false
+	}
+	return &gcl.HTTPRequest{
+		Request:      x.Request,
+		RequestSize:  max(x.Request.ContentLength, 0),
+		Status:       x.Status,
+		ResponseSize: x.ResponseSize,
+		Latency:      x.Latency,
+		RemoteIP:     x.Request.RemoteAddr,
+	}, true
+}
+
+// ExtractStdHTTPRequest extracts a bare *http.Request, for which no response
+// details are known.
+func ExtractStdHTTPRequest(v interface{}) (*gcl.HTTPRequest, bool) {
+	r, ok := v.(*http.Request)
+	if !ok || r == nil || r.URL == nil {
+		return nil, false
+	}
+	return &gcl.HTTPRequest{
+		Request:     r,
+		RequestSize: max(r.ContentLength, 0),
+		RemoteIP:    r.RemoteAddr,
+	}, true
+}
+
+// fromHTTPPayload converts the string-typed payload shared by zapdriver and
+// go-logger. Unparseable numbers and durations are treated as zero.
+func fromHTTPPayload(p *zapdriver.HTTPPayload) *gcl.HTTPRequest {
+	req := &http.Request{
+		Method: p.RequestMethod,
+		Proto:  p.Protocol,
+		Header: make(http.Header),
+	}
+	req.URL, _ = url.Parse(p.RequestURL)
+	if req.URL == nil {
+		req.URL = &url.URL{}
+	}
+	if p.UserAgent != "" {
+		req.Header.Set("User-Agent", p.UserAgent)
+	}
+	if p.Referer != "" {
+		req.Header.Set("Referer", p.Referer)
+	}
+	reqSize, _ := strconv.ParseInt(p.RequestSize, 10, 64)
+	respSize, _ := strconv.ParseInt(p.ResponseSize, 10, 64)
+	fillBytes, _ := strconv.ParseInt(p.CacheFillBytes, 10, 64)
+	latency, _ := time.ParseDuration(p.Latency)
+	return &gcl.HTTPRequest{
+		Request:                        req,
+		RequestSize:                    reqSize,
+		Status:                         p.Status,
+		ResponseSize:                   respSize,
+		Latency:                        latency,
+		LocalIP:                        p.ServerIP,
+		RemoteIP:                       p.RemoteIP,
+		CacheHit:                       p.CacheHit,
+		CacheValidatedWithOriginServer: p.CacheValidatedWithOriginServer,
+		CacheFillBytes:                 fillBytes,
+		CacheLookup:                    p.CacheLookup,
+	}
+}
+
+// extractHTTPRequest moves the HTTPRequestKey field from the payload into the
+// entry, using the Core's HTTPRequestExtractors. Values no extractor
+// recognises are left in the payload, encoded like any other field.
+func (c *Core) extractHTTPRequest(entry *gcl.Entry, payload map[string]interface{}) {
+	v, ok := payload[HTTPRequestKey]
+	if !ok {
+		return
+	}
+
+	extractors := c.HTTPRequestExtractors
+	if extractors == nil {
+		extractors = DefaultHTTPRequestExtractors
+	}
+	for _, extract := range extractors {
+		if r, ok := extract(v); ok {
+			entry.HTTPRequest = r
+			delete(payload, HTTPRequestKey)
+			return
+		}
+	}
+	if fields, ok := objectFields(v); ok {
+		payload[HTTPRequestKey] = fields
+	} else if rv, err := reflectedValue(v); err == nil {
+		payload[HTTPRequestKey] = rv
+	}
+}
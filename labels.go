@@ -0,0 +1,146 @@
+package zapgcl
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	gcl "cloud.google.com/go/logging"
+)
+
+const (
+	// LabelsKey is the payload field key of an object whose members are
+	// added to the labels of the LogEntry object, as written by zapdriver's
+	// Labels helper.
+	LabelsKey = "logging.googleapis.com/labels"
+
+	// LabelPrefix is the payload field key prefix which marks an individual
+	// label, as written by zapdriver's Label helper.
+	LabelPrefix = "labels."
+
+	// Cloud Logging rejects or truncates labels beyond these lengths.
+	maxLabelKeyBytes   = 512
+	maxLabelValueBytes = 64 * 1024
+)
+
+// mergeLabels adds the members of a LabelsKey value to dst. It reports false
+// if v is not an object.
+func mergeLabels(dst map[string]interface{}, v interface{}) bool {
+	fields, ok := objectFields(v)
+	if !ok {
+		return false
+	}
+	for k, lv := range fields {
+		dst[k] = lv
+	}
+	return true
+}
+
+// withLabels returns the context labels of a Core created by With, moving
+// any LabelsKey object out of its fields. LabelPrefix fields are left in
+// place since they merge naturally.
+func withLabels(orig map[string]interface{}, fields map[string]interface{}) map[string]interface{} {
+	v, ok := fields[LabelsKey]
+	if !ok {
+		return orig
+	}
+	labels := make(map[string]interface{}, len(orig))
+	for k, lv := range orig {
+		labels[k] = lv
+	}
+	if !mergeLabels(labels, v) {
+		return orig
+	}
+	delete(fields, LabelsKey)
+	return labels
+}
+
+// labelValue stringifies a scalar label value.
+func labelValue(v interface{}) (string, bool) {
+	switch lv := v.(type) {
+	case string:
+		return lv, true
+	case bool:
+		return strconv.FormatBool(lv), true
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, uintptr:
+		return fmt.Sprint(lv), true
+	case float32:
+		return strconv.FormatFloat(float64(lv), 'g', -1, 32), true
+	case float64:
+		return strconv.FormatFloat(lv, 'g', -1, 64), true
+	case time.Time:
+		return lv.Format(time.RFC3339Nano), true
+	case fmt.Stringer:
+		return lv.String(), true
+	}
+	return "", false
+}
+
+// validateLabel checks a label against Cloud Logging's limits.
+func validateLabel(k, v string) error {
+	switch {
+	case k == "":
+		return fmt.Errorf("empty key")
+	case len(k) > maxLabelKeyBytes:
+		return fmt.Errorf("key longer than %d bytes", maxLabelKeyBytes)
+	case !utf8.ValidString(k):
+		return fmt.Errorf("key is not valid UTF-8")
+	case len(v) > maxLabelValueBytes:
+		return fmt.Errorf("value longer than %d bytes", maxLabelValueBytes)
+	case !utf8.ValidString(v):
+		return fmt.Errorf("value is not valid UTF-8")
+	}
+	return nil
+}
+
+// extractLabels sets the entry's labels from the Core's context labels, the
+// payload's LabelsKey object and its LabelPrefix fields, in increasing order
+// of precedence, removing them from the payload. Labels that are not scalars
+// or that Cloud Logging would reject are dropped and reported in the returned
+// error.
+func (c *Core) extractLabels(entry *gcl.Entry, payload map[string]interface{}) error {
+	raw := make(map[string]interface{}, len(c.labels))
+	for k, v := range c.labels {
+		raw[k] = v
+	}
+	if v, ok := payload[LabelsKey]; ok {
+		if mergeLabels(raw, v) {
+			delete(payload, LabelsKey)
+		}
+	}
+	for k, v := range payload {
+		if strings.HasPrefix(k, LabelPrefix) {
+			raw[strings.TrimPrefix(k, LabelPrefix)] = v
+			delete(payload, k)
+		}
+	}
+	if len(raw) == 0 {
+		return nil
+	}
+
+	var invalid []string
+	entry.Labels = make(map[string]string, len(raw))
+	for k, v := range raw {
+		s, ok := labelValue(v)
+		if !ok {
+			invalid = append(invalid, fmt.Sprintf("%q: unsupported value type %T", k, v))
+			continue
+		}
+		if err := validateLabel(k, s); err != nil {
+			invalid = append(invalid, fmt.Sprintf("%q: %v", k, err))
+			continue
+		}
+		entry.Labels[k] = s
+	}
+	if len(entry.Labels) == 0 {
+		entry.Labels = nil
+	}
+	if len(invalid) > 0 {
+		sort.Strings(invalid)
+		return newError("dropped invalid labels: %s", strings.Join(invalid, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package zapgcl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// WithLevel sets the LevelEnabler of the Core. By default it follows the core
+// being teed, including any zap.AtomicLevel that core was built with; use a
+// DynamicLevel to be able to change the Stackdriver level independently.
+func WithLevel(enab zapcore.LevelEnabler) Option {
+	return optionFunc(func(o *options) {
+		o.level = enab
+	})
+}
+
+// DynamicLevel is a zapcore.LevelEnabler which follows a base LevelEnabler,
+// typically the zap.AtomicLevel of the local output, until it's given a level
+// of its own. It's safe for concurrent use.
+type DynamicLevel struct {
+	base zapcore.LevelEnabler
+
+	// override holds the level set with SetLevel, offset so that zero
+	// means "not set" (see encodeOverride).
+	override atomic.Int32
+}
+
+func encodeOverride(l zapcore.Level) int32 { return int32(l-zapcore.DebugLevel) + 1 }
+func decodeOverride(o int32) zapcore.Level { return zapcore.Level(o-1) + zapcore.DebugLevel }
+
+// NewDynamicLevel returns a DynamicLevel following base. A nil base enables
+// InfoLevel and above.
+func NewDynamicLevel(base zapcore.LevelEnabler) *DynamicLevel {
+	if base == nil {
+		base = zapcore.InfoLevel
+	}
+	return &DynamicLevel{base: base}
+}
+
+// Enabled implements zapcore.LevelEnabler.
+func (d *DynamicLevel) Enabled(l zapcore.Level) bool {
+	if o := d.override.Load(); o != 0 {
+		return l >= decodeOverride(o)
+	}
+	return d.base.Enabled(l)
+}
+
+// Level returns the minimum enabled level, and whether it was set with
+// SetLevel rather than taken from the base.
+func (d *DynamicLevel) Level() (zapcore.Level, bool) {
+	if o := d.override.Load(); o != 0 {
+		return decodeOverride(o), true
+	}
+	return zapcore.LevelOf(d.base), false
+}
+
+// SetLevel stops following the base and enables l and above.
+func (d *DynamicLevel) SetLevel(l zapcore.Level) {
+	d.override.Store(encodeOverride(l))
+}
+
+// Reset resumes following the base.
+func (d *DynamicLevel) Reset() {
+	d.override.Store(0)
+}
+
+type dynamicLevelPayload struct {
+	Level     *zapcore.Level `json:"level,omitempty"`
+	Following bool           `json:"following"`
+}
+
+type errorPayload struct {
+	Error string `json:"error"`
+}
+
+// ServeHTTP is a simple JSON endpoint that can report on or change the
+// Stackdriver level, modelled on zap.AtomicLevel.ServeHTTP.
+//
+// GET reports the current level and whether it follows the base. PUT changes
+// the level; it takes either a JSON body such as {"level":"debug"} or a
+// "level" form value. DELETE resumes following the base.
+func (d *DynamicLevel) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	enc := json.NewEncoder(w)
+	w.Header().Set("Content-Type", "application/json")
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		l, err := decodeLevel(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			enc.Encode(errorPayload{Error: err.Error()})
+			return
+		}
+		d.SetLevel(l)
+	case http.MethodDelete:
+		d.Reset()
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		enc.Encode(errorPayload{Error: "Only GET, PUT and DELETE are supported."})
+		return
+	}
+
+	l, overridden := d.Level()
+	enc.Encode(dynamicLevelPayload{Level: &l, Following: !overridden})
+}
+
+// decodeLevel reads the level from a PUT request.
+func decodeLevel(r *http.Request) (zapcore.Level, error) {
+	var l zapcore.Level
+	if r.Header.Get("Content-Type") == "application/x-www-form-urlencoded" {
+		v := r.FormValue("level")
+		if v == "" {
+			return l, fmt.Errorf("must specify logging level")
+		}
+		return l, l.UnmarshalText([]byte(v))
+	}
+
+	var req dynamicLevelPayload
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return l, fmt.Errorf("request body must be well-formed JSON: %v", err)
+	}
+	if req.Level == nil {
+		return l, fmt.Errorf("must specify logging level")
+	}
+	return *req.Level, nil
+}
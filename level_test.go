@@ -0,0 +1,113 @@
+package zapgcl
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	gcl "cloud.google.com/go/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/api/option"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+)
+
+// newTestClient returns a client which is never expected to reach the
+// logging service.
+func newTestClient(t *testing.T) *gcl.Client {
+	t.Helper()
+	client, err := gcl.NewClient(context.Background(), "projects/test",
+		option.WithoutAuthentication(), option.WithEndpoint("localhost:0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.OnError = func(error) {}
+	return client
+}
+
+// testResource avoids resource detection in tests.
+var testResource = WithResource(&mrpb.MonitoredResource{Type: "global"})
+
+func TestTeeFollowsAtomicLevel(t *testing.T) {
+	lvl := zap.NewAtomicLevelAt(zapcore.InfoLevel)
+	zc := zapcore.NewCore(zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), zapcore.AddSync(&strings.Builder{}), lvl)
+	tee := Tee(zc, newTestClient(t), "test", testResource)
+
+	if tee.Enabled(zapcore.DebugLevel) {
+		t.Fatal("debug should not be enabled yet")
+	}
+	lvl.SetLevel(zapcore.DebugLevel)
+	ce := tee.Check(zapcore.Entry{Level: zapcore.DebugLevel}, nil)
+	if ce == nil {
+		t.Fatal("debug should be enabled after changing the atomic level")
+	}
+}
+
+func TestDynamicLevel(t *testing.T) {
+	base := zap.NewAtomicLevelAt(zapcore.WarnLevel)
+	d := NewDynamicLevel(base)
+	c := &Core{LevelEnabler: d}
+
+	if c.Enabled(zapcore.InfoLevel) {
+		t.Error("info should follow the base and be disabled")
+	}
+	d.SetLevel(zapcore.DebugLevel)
+	if !c.Enabled(zapcore.DebugLevel) {
+		t.Error("debug should be enabled once overridden")
+	}
+	if base.Enabled(zapcore.DebugLevel) {
+		t.Error("the base level must not change")
+	}
+	d.Reset()
+	if c.Enabled(zapcore.InfoLevel) {
+		t.Error("info should be disabled after resetting")
+	}
+}
+
+func TestDynamicLevelServeHTTP(t *testing.T) {
+	d := NewDynamicLevel(zap.NewAtomicLevelAt(zapcore.InfoLevel))
+	srv := httptest.NewServer(d)
+	defer srv.Close()
+
+	do := func(method, contentType, body string) (int, string) {
+		req, err := http.NewRequest(method, srv.URL, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, strings.TrimSpace(string(b))
+	}
+
+	tests := []struct {
+		method, contentType, body string
+		code                      int
+		response                  string
+	}{
+		{"GET", "", "", 200, `{"level":"info","following":true}`},
+		{"PUT", "application/json", `{"level":"debug"}`, 200, `{"level":"debug","following":false}`},
+		{"PUT", "application/x-www-form-urlencoded", "level=error", 200, `{"level":"error","following":false}`},
+		{"PUT", "application/json", `{}`, 400, `{"error":"must specify logging level"}`},
+		{"DELETE", "", "", 200, `{"level":"info","following":true}`},
+		{"POST", "", "", 405, `{"error":"Only GET, PUT and DELETE are supported."}`},
+	}
+	for _, tt := range tests {
+		code, body := do(tt.method, tt.contentType, tt.body)
+		if code != tt.code || body != tt.response {
+			t.Errorf("%s %s: got %d %s, want %d %s", tt.method, tt.body, code, body, tt.code, tt.response)
+		}
+	}
+}
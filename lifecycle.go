@@ -0,0 +1,178 @@
+package zapgcl
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	gcl "cloud.google.com/go/logging"
+	"go.uber.org/multierr"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc/codes"
+)
+
+// SyncContext is like Sync, but gives up when ctx is done. The flush carries
+// on in the background, but entries it hasn't sent by then may be lost.
+func (c *Core) SyncContext(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- c.Sync()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return newError("flushing Google Cloud logger: %v", ctx.Err())
+	}
+}
+
+// A Closer shuts down the GCL client created by NewDevelopmentWithCloser,
+// NewProductionWithCloser or NewAuto. The Logger must not be used once it's
+// closed.
+type Closer struct {
+	core   *Core
+	client *gcl.Client
+	errors *WriteErrors
+
+	// errorOutput is where errors go if errors has no Output.
+	errorOutput zapcore.WriteSyncer
+
+	// closeErrSink closes the ErrorOutputPaths of the zap.Config.
+	closeErrSink func()
+}
+
+// SyncContext flushes buffered entries, giving up when ctx is done.
+func (c *Closer) SyncContext(ctx context.Context) error {
+	return c.core.SyncContext(ctx)
+}
+
+// Close flushes buffered entries and closes the client and the error
+// outputs of the zap.Config, giving up when ctx is done. The returned error
+// also reports the entries which were dropped while writing, if any.
+func (c *Closer) Close(ctx context.Context) error {
+	err := c.close(ctx)
+	c.closeOutputs()
+	return err
+}
+
+// closeOutputs closes the error outputs, which close leaves open so that
+// CloseOnSignal can report its error to them.
+func (c *Closer) closeOutputs() {
+	if c.closeErrSink != nil {
+		c.closeErrSink()
+	}
+}
+
+// close flushes buffered entries and closes the client.
+func (c *Closer) close(ctx context.Context) error {
+	done := make(chan error, 1)
+	go func() {
+		c.core.stopSummaries()
+		err := c.core.Sync()
+		if c.client != nil {
+			err = multierr.Append(err, c.client.Close())
+		}
+		done <- err
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+		if err != nil {
+			err = newError("closing Google Cloud logger: %v", err)
+		}
+	case <-ctx.Done():
+		err = newError("closing Google Cloud logger: %v; buffered entries may be lost", ctx.Err())
+	}
+	if report := c.dropped(); report != "" {
+		err = multierr.Append(err, newError("%s", report))
+	}
+	return err
+}
+
+// dropped summarises the entries the client reported as dropped.
+func (c *Closer) dropped() string {
+	if c.errors == nil {
+		return ""
+	}
+	summary := c.errors.Summary()
+	codeList := make([]codes.Code, 0, len(summary))
+	for code := range summary {
+		codeList = append(codeList, code)
+	}
+	sort.Slice(codeList, func(i, j int) bool { return codeList[i] < codeList[j] })
+
+	var parts []string
+	for _, code := range codeList {
+		s := summary[code]
+		parts = append(parts, fmt.Sprintf("%v: %d errors, %d entries, %d bytes", code, s.Errors, s.Entries, s.Bytes))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return "write errors by code: " + strings.Join(parts, "; ")
+}
+
+// CloseOnSignal closes c within timeout when one of sigs, or SIGTERM if none
+// are given, is received. Any error, such as entries which couldn't be
+// delivered, is written to the WriteErrors Output, or else to the error
+// outputs of the zap.Config or standard error. The signal is then delivered
+// again without the handler, so that the process ends as it otherwise would
+// have; programs which handle the signal themselves should call Close from
+// their own handler instead.
+//
+// The returned function removes the handler.
+func (c *Closer) CloseOnSignal(timeout time.Duration, sigs ...os.Signal) (stop func()) {
+	if len(sigs) == 0 {
+		sigs = []os.Signal{syscall.SIGTERM}
+	}
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, sigs...)
+	quit := make(chan struct{})
+
+	go func() {
+		select {
+		case sig := <-ch:
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			err := c.close(ctx)
+			cancel()
+			if err != nil {
+				c.report(err)
+			}
+			c.closeOutputs()
+			signal.Stop(ch)
+			if p, err := os.FindProcess(os.Getpid()); err == nil {
+				p.Signal(sig)
+			}
+		case <-quit:
+		}
+	}()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			signal.Stop(ch)
+			close(quit)
+		})
+	}
+}
+
+// report writes an error from CloseOnSignal where it can be seen.
+func (c *Closer) report(err error) {
+	out := c.errorOutput
+	if c.errors != nil && c.errors.Output != nil {
+		out = c.errors.Output
+	}
+	if out != nil {
+		fmt.Fprintf(out, "%v %v\n", time.Now().UTC(), err)
+		out.Sync()
+		return
+	}
+	fmt.Fprintf(os.Stderr, "%v %v\n", time.Now().UTC(), err)
+}
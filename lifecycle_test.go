@@ -0,0 +1,116 @@
+package zapgcl
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// stuckLogger is a testLogger whose Flush blocks until unblocked.
+type stuckLogger struct {
+	testLogger
+	unblock chan struct{}
+}
+
+func (s *stuckLogger) Flush() error {
+	<-s.unblock
+	return nil
+}
+
+func TestCoreSyncContext(t *testing.T) {
+	l := &stuckLogger{unblock: make(chan struct{})}
+	defer close(l.unblock)
+	c := &Core{Logger: l}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := c.SyncContext(ctx)
+	if err == nil || !strings.Contains(err.Error(), context.DeadlineExceeded.Error()) {
+		t.Errorf("expected a deadline error, got %v", err)
+	}
+
+	if err := (&Core{Logger: &testLogger{}}).SyncContext(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCloserClose(t *testing.T) {
+	client := newTestClient(t)
+	we := &WriteErrors{}
+	c, err := NewCore(client, "log", testResource, WithWriteErrors(we))
+	if err != nil {
+		t.Fatal(err)
+	}
+	closer := &Closer{core: c, client: client, errors: we}
+
+	if err := closer.SyncContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	client.OnError(&writeError{err: status.Error(codes.DeadlineExceeded, "timeout"), sizes: []int{100}})
+	err = closer.Close(context.Background())
+	if err == nil {
+		t.Fatal("expected the dropped entries to be reported")
+	}
+	expected := codes.DeadlineExceeded.String() + ": 1 errors, 1 entries, 100 bytes"
+	if !strings.Contains(err.Error(), expected) {
+		t.Errorf("expected %q in %q", expected, err)
+	}
+}
+
+// countingSink is a zap.Sink counting how often it's opened and closed.
+type countingSink struct {
+	zapcore.WriteSyncer
+	closed *atomic.Int32
+}
+
+func (s countingSink) Close() error {
+	s.closed.Add(1)
+	return nil
+}
+
+var (
+	sinkOpened, sinkClosed atomic.Int32
+	registerSink           sync.Once
+)
+
+func TestCloserClosesErrorOutput(t *testing.T) {
+	registerSink.Do(func() {
+		err := zap.RegisterSink("zapgcltest", func(*url.URL) (zap.Sink, error) {
+			sinkOpened.Add(1)
+			return countingSink{zapcore.AddSync(io.Discard), &sinkClosed}, nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+	sinkOpened.Store(0)
+	sinkClosed.Store(0)
+
+	cfg := zap.NewProductionConfig()
+	cfg.OutputPaths = nil
+	cfg.ErrorOutputPaths = []string{"zapgcltest://errors"}
+	_, closer, err := NewAuto(cfg, "proj", "log", WithTransport(TransportStdout), WithStructuredOutput(io.Discard), testResource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := sinkOpened.Load(); n != 1 {
+		t.Errorf("expected the error output to be opened once, got %d", n)
+	}
+	if err := closer.Close(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if n := sinkClosed.Load(); n != 1 {
+		t.Errorf("expected the error output to be closed, got %d", n)
+	}
+}
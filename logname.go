@@ -0,0 +1,109 @@
+package zapgcl
+
+import (
+	"strings"
+	"sync"
+
+	"go.uber.org/multierr"
+)
+
+// A LoggerNamePolicy decides what a Core does with the name given to a logger
+// by zap.Logger.Named.
+type LoggerNamePolicy int
+
+const (
+	// LoggerNameField writes the name to the "logger" payload field.
+	LoggerNameField LoggerNamePolicy = iota
+
+	// LoggerNameLogID writes entries from each named logger to a log of
+	// their own, whose ID is derived from the name with Core.LogIDForName.
+	// Entries from unnamed loggers go to the Core's Logger as usual.
+	LoggerNameLogID
+)
+
+// WithLoggerNamePolicy sets what happens to the names of named loggers. The
+// default is LoggerNameField.
+func WithLoggerNamePolicy(p LoggerNamePolicy) Option {
+	return optionFunc(func(o *options) {
+		o.loggerNamePolicy = p
+	})
+}
+
+// WithLogIDForName sets how logger names are turned into log IDs under the
+// LoggerNameLogID policy. The default is DefaultLogIDForName.
+func WithLogIDForName(f func(name string) string) Option {
+	return optionFunc(func(o *options) {
+		o.logIDForName = f
+	})
+}
+
+// DefaultLogIDForName turns a logger name into a log ID by replacing the
+// characters a log ID can't contain with underscores.
+func DefaultLogIDForName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+			return r
+		case r == '/' || r == '_' || r == '-' || r == '.':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// loggerCache lazily creates the GoogleCloudLoggers of named loggers. It is
+// shared by a Core and all of its children.
+type loggerCache struct {
+	newLogger func(logID string) GoogleCloudLogger
+
+	mu      sync.Mutex
+	loggers map[string]GoogleCloudLogger
+}
+
+func newLoggerCache(newLogger func(logID string) GoogleCloudLogger) *loggerCache {
+	return &loggerCache{
+		newLogger: newLogger,
+		loggers:   make(map[string]GoogleCloudLogger),
+	}
+}
+
+// get returns the logger for logID, creating it if necessary.
+func (lc *loggerCache) get(logID string) GoogleCloudLogger {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	l, ok := lc.loggers[logID]
+	if !ok {
+		l = lc.newLogger(logID)
+		lc.loggers[logID] = l
+	}
+	return l
+}
+
+// flush flushes every logger created so far.
+func (lc *loggerCache) flush() error {
+	lc.mu.Lock()
+	loggers := make([]GoogleCloudLogger, 0, len(lc.loggers))
+	for _, l := range lc.loggers {
+		loggers = append(loggers, l)
+	}
+	lc.mu.Unlock()
+
+	var err error
+	for _, l := range loggers {
+		err = multierr.Append(err, l.Flush())
+	}
+	return err
+}
+
+// loggerFor returns the logger an entry from the named logger is written to,
+// and reports whether the name has been used up choosing it.
+func (c *Core) loggerFor(name string) (GoogleCloudLogger, bool) {
+	if name == "" || c.LoggerNames != LoggerNameLogID || c.loggers == nil {
+		return c.Logger, false
+	}
+	logIDForName := c.LogIDForName
+	if logIDForName == nil {
+		logIDForName = DefaultLogIDForName
+	}
+	return c.loggers.get(logIDForName(name)), true
+}
@@ -0,0 +1,56 @@
+package zapgcl
+
+import (
+	gcl "cloud.google.com/go/logging"
+	"cloud.google.com/go/logging/apiv2/loggingpb"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// OperationKey is the payload field key to use to set the operation
+	// field in the LogEntry object. Both zapdriver's and go-logger's
+	// Operation helpers write to this key.
+	OperationKey = "logging.googleapis.com/operation"
+)
+
+// objectFields returns the fields of a structured payload value, either by
+// running an ObjectMarshaler against a payloadEncoder or by returning an
+// already-built map.
+func objectFields(v interface{}) (map[string]interface{}, bool) {
+	switch o := v.(type) {
+	case map[string]interface{}:
+		return o, true
+	case zapcore.ObjectMarshaler:
+		enc := newPayloadEncoder(make(map[string]interface{}))
+		if err := safeMarshal(func() error { return o.MarshalLogObject(enc) }); err != nil {
+			return nil, false
+		}
+		return enc.root, true
+	}
+	return nil, false
+}
+
+// extractOperation moves the OperationKey field from the payload into the
+// entry. Values which don't look like an operation are left in the payload.
+func (c *Core) extractOperation(entry *gcl.Entry, payload map[string]interface{}) {
+	v, ok := payload[OperationKey]
+	if !ok {
+		return
+	}
+	fields, ok := objectFields(v)
+	if !ok {
+		return
+	}
+
+	op := &loggingpb.LogEntryOperation{}
+	op.Id, _ = fields["id"].(string)
+	op.Producer, _ = fields["producer"].(string)
+	op.First, _ = fields["first"].(bool)
+	op.Last, _ = fields["last"].(bool)
+	if op.Id == "" && op.Producer == "" {
+		return
+	}
+
+	entry.Operation = op
+	delete(payload, OperationKey)
+}
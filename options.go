@@ -0,0 +1,159 @@
+package zapgcl
+
+import (
+	"io"
+	"strings"
+	"time"
+
+	gcl "cloud.google.com/go/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+)
+
+// An Option configures the Core built by NewCore, Tee and NewWithOptions.
+type Option interface {
+	apply(*options)
+}
+
+type optionFunc func(*options)
+
+func (f optionFunc) apply(o *options) { f(o) }
+
+type options struct {
+	projectID string
+	resource  *mrpb.MonitoredResource
+	detector  *ResourceDetector
+	zapOpts   []zap.Option
+
+	errorReporting   *ErrorReporting
+	loggerNamePolicy LoggerNamePolicy
+	logIDForName     func(name string) string
+	level            zapcore.LevelEnabler
+
+	severityMapping map[zapcore.Level]gcl.Severity
+	commonLabels    map[string]string
+	loggerOpts      []gcl.LoggerOption
+	logger          GoogleCloudLogger
+	extractors      []HTTPRequestExtractor
+
+	oversizePolicy OversizePolicy
+	maxEntrySize   int
+	writeErrors    *WriteErrors
+	errorOutput    zapcore.WriteSyncer
+	syncLevel      zapcore.LevelEnabler
+	syncTimeout    time.Duration
+	budget         *Budget
+	redaction      *Redaction
+
+	transport        Transport
+	structuredOutput io.Writer
+	standalone       bool // the Core replaces the local core
+}
+
+func newOptions(opts []Option) *options {
+	o := &options{syncLevel: zapcore.DPanicLevel}
+	for _, opt := range opts {
+		opt.apply(o)
+	}
+	return o
+}
+
+// detectResource returns the configured or detected resource, if any.
+func (o *options) detectResource() *mrpb.MonitoredResource {
+	if o.resource == nil && o.detector != nil {
+		return o.detector.Detect()
+	}
+	return o.resource
+}
+
+// loggerOptions returns the options to pass to gcl.Client.Logger. Those set
+// with WithLoggerOptions come last, so they take precedence.
+func (o *options) loggerOptions(res *mrpb.MonitoredResource) []gcl.LoggerOption {
+	var lopts []gcl.LoggerOption
+	if res != nil {
+		lopts = append(lopts, gcl.CommonResource(res))
+	}
+	if len(o.commonLabels) > 0 {
+		lopts = append(lopts, gcl.CommonLabels(o.commonLabels))
+	}
+	return append(lopts, o.loggerOpts...)
+}
+
+// WithProjectID sets the project used to expand bare trace IDs (see
+// Core.ProjectID). A "projects/" prefix is ignored.
+func WithProjectID(projectID string) Option {
+	return optionFunc(func(o *options) {
+		o.projectID = strings.TrimPrefix(projectID, "projects/")
+	})
+}
+
+// WithResource sets the MonitoredResource all entries are written against.
+// It takes precedence over WithResourceDetection.
+func WithResource(res *mrpb.MonitoredResource) Option {
+	return optionFunc(func(o *options) {
+		o.resource = res
+	})
+}
+
+// WithResourceDetection detects the MonitoredResource entries are written
+// against when the Core is built. If d is nil, a ResourceDetector with
+// default settings is used. If nothing is detected, the GCL client's own
+// detection applies.
+func WithResourceDetection(d *ResourceDetector) Option {
+	return optionFunc(func(o *options) {
+		if d == nil {
+			d = &ResourceDetector{}
+		}
+		o.detector = d
+	})
+}
+
+// WithZapOptions adds options to the zap.Logger built by NewWithOptions. They
+// are applied after, and so override, those derived from the zap.Config.
+func WithZapOptions(opts ...zap.Option) Option {
+	return optionFunc(func(o *options) {
+		o.zapOpts = append(o.zapOpts, opts...)
+	})
+}
+
+// WithSeverityMapping sets the mapping of zap's Levels to Google's Severities.
+// The default is DefaultSeverityMapping.
+func WithSeverityMapping(m map[zapcore.Level]gcl.Severity) Option {
+	return optionFunc(func(o *options) {
+		o.severityMapping = m
+	})
+}
+
+// WithCommonLabels sets labels which are added to every entry.
+func WithCommonLabels(labels map[string]string) Option {
+	return optionFunc(func(o *options) {
+		o.commonLabels = labels
+	})
+}
+
+// WithLoggerOptions passes options, such as gcl.DelayThreshold,
+// gcl.EntryCountThreshold, gcl.BufferedByteLimit, gcl.ConcurrentWriteLimit or
+// gcl.PartialSuccess, through to every gcl.Logger the Core creates.
+func WithLoggerOptions(opts ...gcl.LoggerOption) Option {
+	return optionFunc(func(o *options) {
+		o.loggerOpts = append(o.loggerOpts, opts...)
+	})
+}
+
+// WithGoogleCloudLogger makes the Core write to l instead of creating a
+// gcl.Logger from the client. Since gcl.LoggerOptions can't be applied to l,
+// the resource and common labels are set on each entry instead.
+func WithGoogleCloudLogger(l GoogleCloudLogger) Option {
+	return optionFunc(func(o *options) {
+		o.logger = l
+	})
+}
+
+// WithHTTPRequestExtractors sets how httpRequest fields are interpreted. The
+// default is DefaultHTTPRequestExtractors.
+func WithHTTPRequestExtractors(extractors ...HTTPRequestExtractor) Option {
+	return optionFunc(func(o *options) {
+		o.extractors = extractors
+	})
+}
@@ -0,0 +1,290 @@
+package zapgcl
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// payloadEncoder is a zapcore.ObjectEncoder which builds a jsonPayload as
+// nested maps. Values are normalised so that, once the GCL client has
+// marshaled them to JSON, they read the same as the output of zap's JSON
+// encoder: marshalers are run, floats which JSON can't represent become the
+// strings "NaN", "+Inf" and "-Inf", complex numbers become strings like
+// "1+2i", binary becomes base64 and reflected values are round-tripped
+// through encoding/json. Times are kept as time.Time and durations are
+// rendered with time.Duration.String.
+type payloadEncoder struct {
+	root map[string]interface{}
+
+	// cur is the map fields are added to, which is not root once a
+	// namespace has been opened; ns is the path from root to cur.
+	cur map[string]interface{}
+	ns  []string
+}
+
+// newPayloadEncoder returns an encoder which writes to m.
+func newPayloadEncoder(m map[string]interface{}) *payloadEncoder {
+	return &payloadEncoder{root: m, cur: m}
+}
+
+// clone creates a new field map without mutating the original. Fields are
+// added inside the namespace ns, and the namespace in effect once all of
+// newFields have been added is returned alongside the map.
+func clone(orig map[string]interface{}, ns []string, newFields []zapcore.Field) (map[string]interface{}, []string) {
+	enc := newPayloadEncoder(make(map[string]interface{}, len(orig)+len(newFields)))
+	for k, v := range orig {
+		enc.root[k] = v
+	}
+
+	// Maps along the namespace path are shared with orig, so they have to be
+	// copied before they are written to.
+	for _, k := range ns {
+		m := make(map[string]interface{})
+		if prev, ok := enc.cur[k].(map[string]interface{}); ok {
+			for pk, pv := range prev {
+				m[pk] = pv
+			}
+		}
+		enc.cur[k] = m
+		enc.cur = m
+	}
+	enc.ns = append([]string(nil), ns...)
+
+	for _, f := range newFields {
+		switch {
+		case f.Type == zapcore.UnknownType:
+			// Fields built by hand rather than with the zap constructors.
+			enc.cur[f.Key] = f.Interface
+		case f.Key == HTTPRequestKey && len(enc.ns) == 0 &&
+			(f.Type == zapcore.ObjectMarshalerType || f.Type == zapcore.ReflectType):
+			// Kept as-is for the HTTPRequestExtractors.
+			enc.cur[f.Key] = f.Interface
+		default:
+			f.AddTo(enc)
+		}
+	}
+
+	if len(enc.ns) == 0 {
+		enc.ns = nil
+	}
+	return enc.root, enc.ns
+}
+
+// setContext keeps the context of a Context field at the root.
+func (e *payloadEncoder) setContext(ctx context.Context) {
+	e.root[ContextKey] = ctx
+}
+
+// AddArray implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddArray(key string, v zapcore.ArrayMarshaler) error {
+	arr := &sliceEncoder{elems: make([]interface{}, 0)}
+	err := safeMarshal(func() error { return v.MarshalLogArray(arr) })
+	e.cur[key] = arr.elems
+	return err
+}
+
+// AddObject implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddObject(key string, v zapcore.ObjectMarshaler) error {
+	m := newPayloadEncoder(make(map[string]interface{}))
+	err := safeMarshal(func() error { return v.MarshalLogObject(m) })
+	e.cur[key] = m.root
+	return err
+}
+
+// AddBinary implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddBinary(k string, v []byte) {
+	e.cur[k] = base64.StdEncoding.EncodeToString(v)
+}
+
+// AddByteString implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddByteString(k string, v []byte) { e.cur[k] = string(v) }
+
+// AddBool implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddBool(k string, v bool) { e.cur[k] = v }
+
+// AddDuration implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddDuration(k string, v time.Duration) { e.cur[k] = v.String() }
+
+// AddComplex128 implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddComplex128(k string, v complex128) { e.cur[k] = formatComplex(v, 64) }
+
+// AddComplex64 implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddComplex64(k string, v complex64) {
+	e.cur[k] = formatComplex(complex128(v), 32)
+}
+
+// AddFloat64 implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddFloat64(k string, v float64) { e.cur[k] = floatValue(v, v) }
+
+// AddFloat32 implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddFloat32(k string, v float32) { e.cur[k] = floatValue(float64(v), v) }
+
+// AddInt implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddInt(k string, v int) { e.cur[k] = v }
+
+// AddInt64 implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddInt64(k string, v int64) { e.cur[k] = v }
+
+// AddInt32 implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddInt32(k string, v int32) { e.cur[k] = v }
+
+// AddInt16 implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddInt16(k string, v int16) { e.cur[k] = v }
+
+// AddInt8 implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddInt8(k string, v int8) { e.cur[k] = v }
+
+// AddString implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddString(k string, v string) { e.cur[k] = v }
+
+// AddTime implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddTime(k string, v time.Time) { e.cur[k] = v }
+
+// AddUint implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddUint(k string, v uint) { e.cur[k] = v }
+
+// AddUint64 implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddUint64(k string, v uint64) { e.cur[k] = v }
+
+// AddUint32 implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddUint32(k string, v uint32) { e.cur[k] = v }
+
+// AddUint16 implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddUint16(k string, v uint16) { e.cur[k] = v }
+
+// AddUint8 implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddUint8(k string, v uint8) { e.cur[k] = v }
+
+// AddUintptr implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddUintptr(k string, v uintptr) { e.cur[k] = v }
+
+// AddReflected implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) AddReflected(k string, v interface{}) error {
+	rv, err := reflectedValue(v)
+	if err != nil {
+		return err
+	}
+	e.cur[k] = rv
+	return nil
+}
+
+// OpenNamespace implements zapcore.ObjectEncoder.
+func (e *payloadEncoder) OpenNamespace(k string) {
+	ns := make(map[string]interface{})
+	e.cur[k] = ns
+	e.cur = ns
+	e.ns = append(e.ns, k)
+}
+
+// sliceEncoder is the zapcore.ArrayEncoder counterpart of payloadEncoder.
+type sliceEncoder struct {
+	elems []interface{}
+}
+
+func (s *sliceEncoder) AppendArray(v zapcore.ArrayMarshaler) error {
+	arr := &sliceEncoder{elems: make([]interface{}, 0)}
+	err := safeMarshal(func() error { return v.MarshalLogArray(arr) })
+	s.elems = append(s.elems, arr.elems)
+	return err
+}
+
+func (s *sliceEncoder) AppendObject(v zapcore.ObjectMarshaler) error {
+	m := newPayloadEncoder(make(map[string]interface{}))
+	err := safeMarshal(func() error { return v.MarshalLogObject(m) })
+	s.elems = append(s.elems, m.root)
+	return err
+}
+
+func (s *sliceEncoder) AppendReflected(v interface{}) error {
+	rv, err := reflectedValue(v)
+	if err != nil {
+		return err
+	}
+	s.elems = append(s.elems, rv)
+	return nil
+}
+
+func (s *sliceEncoder) AppendBool(v bool)         { s.elems = append(s.elems, v) }
+func (s *sliceEncoder) AppendByteString(v []byte) { s.elems = append(s.elems, string(v)) }
+func (s *sliceEncoder) AppendComplex128(v complex128) {
+	s.elems = append(s.elems, formatComplex(v, 64))
+}
+func (s *sliceEncoder) AppendComplex64(v complex64) {
+	s.elems = append(s.elems, formatComplex(complex128(v), 32))
+}
+func (s *sliceEncoder) AppendFloat64(v float64)        { s.elems = append(s.elems, floatValue(v, v)) }
+func (s *sliceEncoder) AppendFloat32(v float32)        { s.elems = append(s.elems, floatValue(float64(v), v)) }
+func (s *sliceEncoder) AppendInt(v int)                { s.elems = append(s.elems, v) }
+func (s *sliceEncoder) AppendInt64(v int64)            { s.elems = append(s.elems, v) }
+func (s *sliceEncoder) AppendInt32(v int32)            { s.elems = append(s.elems, v) }
+func (s *sliceEncoder) AppendInt16(v int16)            { s.elems = append(s.elems, v) }
+func (s *sliceEncoder) AppendInt8(v int8)              { s.elems = append(s.elems, v) }
+func (s *sliceEncoder) AppendString(v string)          { s.elems = append(s.elems, v) }
+func (s *sliceEncoder) AppendTime(v time.Time)         { s.elems = append(s.elems, v) }
+func (s *sliceEncoder) AppendUint(v uint)              { s.elems = append(s.elems, v) }
+func (s *sliceEncoder) AppendUint64(v uint64)          { s.elems = append(s.elems, v) }
+func (s *sliceEncoder) AppendUint32(v uint32)          { s.elems = append(s.elems, v) }
+func (s *sliceEncoder) AppendUint16(v uint16)          { s.elems = append(s.elems, v) }
+func (s *sliceEncoder) AppendUint8(v uint8)            { s.elems = append(s.elems, v) }
+func (s *sliceEncoder) AppendUintptr(v uintptr)        { s.elems = append(s.elems, v) }
+func (s *sliceEncoder) AppendDuration(v time.Duration) { s.elems = append(s.elems, v.String()) }
+
+// safeMarshal runs a marshaler, turning a panic (typically a nil pointer
+// with a value receiver) into an error the way zap does for Stringers.
+func safeMarshal(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("PANIC=%v", r)
+		}
+	}()
+	return f()
+}
+
+// floatValue returns v unless JSON can't represent f, in which case it
+// returns the string zap's JSON encoder would write.
+func floatValue(f float64, v interface{}) interface{} {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	}
+	return v
+}
+
+// formatComplex renders a complex number the way zap's JSON encoder does.
+func formatComplex(v complex128, bitSize int) string {
+	r, i := real(v), imag(v)
+	s := strconv.FormatFloat(r, 'f', -1, bitSize)
+	if i >= 0 {
+		s += "+"
+	}
+	return s + strconv.FormatFloat(i, 'f', -1, bitSize) + "i"
+}
+
+// reflectedValue round-trips v through encoding/json, as zap's JSON encoder
+// does, so that unmarshalable values are reported rather than making the
+// whole entry fail later. Numbers are kept as json.Number to stay exact.
+func reflectedValue(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var rv interface{}
+	if err := dec.Decode(&rv); err != nil {
+		return nil, err
+	}
+	return rv, nil
+}
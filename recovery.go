@@ -0,0 +1,139 @@
+package zapgcl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"runtime/debug"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// DefaultMaskedHeaders are the request headers whose values the recovery
+// middleware masks in the request dumps it logs.
+var DefaultMaskedHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie", "X-Api-Key"}
+
+// A PanicError is a value a handler panicked with, and the stack of the
+// goroutine that panicked.
+type PanicError struct {
+	Value interface{}
+	Stack []byte
+}
+
+// Error implements error.
+func (e *PanicError) Error() string {
+	return fmt.Sprint(e.Value)
+}
+
+// Unwrap returns the panic value if it's an error.
+func (e *PanicError) Unwrap() error {
+	err, _ := e.Value.(error)
+	return err
+}
+
+// A RecoveryHandler responds to a request whose handler panicked.
+type RecoveryHandler func(w http.ResponseWriter, r *http.Request, err *PanicError)
+
+// DefaultRecoveryHandler responds with a 500 status and no body.
+func DefaultRecoveryHandler(w http.ResponseWriter, _ *http.Request, _ *PanicError) {
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+// JSONRecoveryHandler responds with a 500 status and a JSON body in the
+// format of Google APIs errors, which doesn't reveal the panic value.
+func JSONRecoveryHandler(w http.ResponseWriter, _ *http.Request, _ *PanicError) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(map[string]interface{}{ // nolint: errcheck
+		"error": map[string]interface{}{
+			"code":    http.StatusInternalServerError,
+			"status":  "INTERNAL",
+			"message": http.StatusText(http.StatusInternalServerError),
+		},
+	})
+}
+
+// WithMaskedHeaders sets the request headers whose values the recovery
+// middleware masks in the request dumps it logs. The default is
+// DefaultMaskedHeaders.
+func WithMaskedHeaders(names ...string) MiddlewareOption {
+	return middlewareOptionFunc(func(o *middlewareOptions) {
+		o.maskedHeaders = names
+		o.maskHeadersSet = true
+	})
+}
+
+// WithRecoveryHandler sets how the recovery middleware responds after a
+// panic. The default is DefaultRecoveryHandler; JSONRecoveryHandler responds
+// with a JSON error body.
+func WithRecoveryHandler(h RecoveryHandler) MiddlewareOption {
+	return middlewareOptionFunc(func(o *middlewareOptions) {
+		o.recoveryHandler = h
+	})
+}
+
+// isBrokenConnection reports whether err means the client went away, which
+// doesn't warrant a stack trace, and to which nothing can be written.
+func isBrokenConnection(err error) bool {
+	return errors.Is(err, http.ErrAbortHandler) || errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// dumpRequest dumps r without its body, masking the values of the headers
+// of the options.
+func (o *middlewareOptions) dumpRequest(r *http.Request) string {
+	masked := o.maskedHeaders
+	if !o.maskHeadersSet {
+		masked = DefaultMaskedHeaders
+	}
+	r = r.Clone(r.Context())
+	for _, h := range masked {
+		if r.Header.Get(h) != "" {
+			r.Header.Set(h, DefaultRedactionMask)
+		}
+	}
+	dump, _ := httputil.DumpRequest(r, false)
+	return string(dump)
+}
+
+// logPanic logs the panic of the handler of r with the value v, returning it
+// as a PanicError, and whether the connection is broken, in which case it
+// isn't logged as a panic.
+//
+// The message of a panic is "panic: <value>", followed by the stack of the
+// goroutine if WithStack is set, as a Go program would crash with, which is
+// what Error Reporting recognises.
+func (o *middlewareOptions) logPanic(logger *zap.Logger, r *http.Request, v interface{}) (*PanicError, bool) {
+	err := &PanicError{Value: v, Stack: debug.Stack()}
+	if isBrokenConnection(err) {
+		logger.Error(r.URL.Path,
+			zap.Error(err),
+			zap.String("request", o.dumpRequest(r)),
+		)
+		return err, true
+	}
+
+	msg := "panic: " + err.Error()
+	if o.stack {
+		msg += "\n\n" + string(err.Stack)
+	}
+	logger.Error(msg,
+		zap.Time("time", time.Now()),
+		zap.Error(err),
+		zap.String("request", o.dumpRequest(r)),
+	)
+	return err, false
+}
+
+// recover responds to r after a panic, with the RecoveryHandler of the
+// options.
+func (o *middlewareOptions) recover(w http.ResponseWriter, r *http.Request, err *PanicError) {
+	h := o.recoveryHandler
+	if h == nil {
+		h = DefaultRecoveryHandler
+	}
+	h(w, r, err)
+}
@@ -0,0 +1,363 @@
+package zapgcl
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	gcl "cloud.google.com/go/logging"
+)
+
+// DefaultRedactionMask replaces masked values if Redaction.Mask is empty.
+const DefaultRedactionMask = "[REDACTED]"
+
+// Patterns of common secrets and personal data, for RedactionRule.Values.
+var (
+	JWTPattern        = regexp.MustCompile(`eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+\.[A-Za-z0-9_-]*`)
+	EmailPattern      = regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`)
+	CardNumberPattern = regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`)
+)
+
+// A RedactAction is what a RedactionRule does to what it matches.
+type RedactAction int
+
+const (
+	// RedactMask replaces values with the Redaction's Mask.
+	RedactMask RedactAction = iota
+
+	// RedactDrop removes fields, labels, query parameters and headers.
+	// A field with a string matching a value pattern is removed whole.
+	RedactDrop
+
+	// RedactHash replaces values with their hex-encoded HMAC-SHA256 under
+	// the Redaction's HMACKey, so that equal values can still be told
+	// apart. Without a key, values are masked instead.
+	RedactHash
+)
+
+// A RedactionRule selects what to redact, and how.
+type RedactionRule struct {
+	// Keys are the names of fields, at any depth, of labels and of URL
+	// query parameters. They are matched case-insensitively.
+	Keys []string
+
+	// Paths are dotted paths of fields from the payload's root, such as
+	// "user.email", with namespaces and objects as path elements. Array
+	// elements share the path of the array.
+	Paths []string
+
+	// Values are matched against strings: field values, label values and
+	// query parameter values. The parts which match are redacted.
+	Values []*regexp.Regexp
+
+	// Headers are names of HTTP headers, matched case-insensitively. They
+	// are redacted from the httpRequest, from "Name: value" lines of
+	// strings, such as the request dump of RecoveryWithZap, and from
+	// fields with those names, such as those of an encoded http.Header.
+	Headers []string
+
+	Action RedactAction
+}
+
+// A Redaction scrubs entries before they leave the process. Fields and
+// labels matched by a rule's Keys, Paths or Headers are redacted whole, by
+// the first such rule; the value patterns of every rule then apply to the
+// rest, in order.
+type Redaction struct {
+	Rules []RedactionRule
+
+	// Mask replaces masked values; DefaultRedactionMask if empty.
+	Mask string
+
+	// HMACKey is the key of RedactHash.
+	HMACKey []byte
+}
+
+// WithRedaction scrubs the payload, labels and httpRequest of entries,
+// including the fields added with With, before they are truncated or split
+// (see Core.Write) and written.
+func WithRedaction(r Redaction) Option {
+	return optionFunc(func(o *options) {
+		o.redaction = &r
+	})
+}
+
+// headerLineRE matches "Name: value" lines, as found in HTTP dumps.
+var headerLineRE = regexp.MustCompile(`(?m)^([A-Za-z0-9-]+)[ \t]*:[ \t]*([^\r\n]*)`)
+
+// redactor is a Redaction ready to apply. It's read-only once built.
+type redactor struct {
+	mask    string
+	hmacKey []byte
+
+	keys    map[string]*RedactionRule // lower-cased keys and headers
+	paths   map[string]*RedactionRule
+	headers map[string]*RedactionRule // lower-cased
+	values  []valueRule
+}
+
+type valueRule struct {
+	re   *regexp.Regexp
+	rule *RedactionRule
+}
+
+func newRedactor(red *Redaction) *redactor {
+	r := &redactor{
+		mask:    red.Mask,
+		hmacKey: red.HMACKey,
+		keys:    make(map[string]*RedactionRule),
+		paths:   make(map[string]*RedactionRule),
+		headers: make(map[string]*RedactionRule),
+	}
+	if r.mask == "" {
+		r.mask = DefaultRedactionMask
+	}
+	add := func(m map[string]*RedactionRule, k string, rule *RedactionRule) {
+		if _, ok := m[k]; !ok {
+			m[k] = rule
+		}
+	}
+	for i := range red.Rules {
+		rule := &red.Rules[i]
+		for _, k := range rule.Keys {
+			add(r.keys, strings.ToLower(k), rule)
+		}
+		for _, p := range rule.Paths {
+			add(r.paths, p, rule)
+		}
+		for _, h := range rule.Headers {
+			add(r.headers, strings.ToLower(h), rule)
+			add(r.keys, strings.ToLower(h), rule)
+		}
+		for _, re := range rule.Values {
+			r.values = append(r.values, valueRule{re: re, rule: rule})
+		}
+	}
+	return r
+}
+
+// replacement returns what replaces s under rule, whose action isn't
+// RedactDrop.
+func (r *redactor) replacement(rule *RedactionRule, s string) string {
+	if rule.Action == RedactHash && len(r.hmacKey) > 0 {
+		mac := hmac.New(sha256.New, r.hmacKey)
+		mac.Write([]byte(s))
+		return hex.EncodeToString(mac.Sum(nil))
+	}
+	return r.mask
+}
+
+// replaceValue returns what replaces v, of any type, under rule.
+func (r *redactor) replaceValue(rule *RedactionRule, v interface{}) interface{} {
+	s, ok := v.(string)
+	if !ok {
+		if b, err := json.Marshal(v); err == nil {
+			s = string(b)
+		} else {
+			s = fmt.Sprint(v)
+		}
+	}
+	return r.replacement(rule, s)
+}
+
+// fields redacts the fields of src, whose path is prefix, into dst, which
+// may be src itself. Nested maps and slices are copied rather than modified,
+// since they may be shared with the Core's context fields.
+func (r *redactor) fields(dst, src map[string]interface{}, prefix string) {
+	for k, v := range src {
+		path := k
+		if prefix != "" {
+			path = prefix + "." + k
+		}
+		rule := r.paths[path]
+		if rule == nil {
+			rule = r.keys[strings.ToLower(k)]
+		}
+		if rule != nil {
+			if rule.Action == RedactDrop {
+				delete(dst, k)
+			} else {
+				dst[k] = r.replaceValue(rule, v)
+			}
+			continue
+		}
+		if nv, keep := r.value(v, path); keep {
+			dst[k] = nv
+		} else {
+			delete(dst, k)
+		}
+	}
+}
+
+// value returns v, whose path is path, redacted, or false if it's dropped.
+func (r *redactor) value(v interface{}, path string) (interface{}, bool) {
+	switch v := v.(type) {
+	case string:
+		return r.text(v)
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		r.fields(m, v, path)
+		return m, true
+	case []interface{}:
+		s := make([]interface{}, 0, len(v))
+		for _, e := range v {
+			if ne, keep := r.value(e, path); keep {
+				s = append(s, ne)
+			}
+		}
+		return s, true
+	}
+	return v, true
+}
+
+// text redacts header lines and value patterns from s, or returns false if
+// a RedactDrop pattern matches it.
+func (r *redactor) text(s string) (string, bool) {
+	s = r.headerLines(s)
+	for _, vr := range r.values {
+		if !vr.re.MatchString(s) {
+			continue
+		}
+		if vr.rule.Action == RedactDrop {
+			return "", false
+		}
+		s = vr.re.ReplaceAllStringFunc(s, func(m string) string {
+			return r.replacement(vr.rule, m)
+		})
+	}
+	return s, true
+}
+
+// headerLines redacts the values of the "Name: value" lines of s whose names
+// are those of headers, dropping the whole line for RedactDrop.
+func (r *redactor) headerLines(s string) string {
+	if len(r.headers) == 0 || !strings.Contains(s, ":") {
+		return s
+	}
+	var b strings.Builder
+	last := 0
+	for _, m := range headerLineRE.FindAllStringSubmatchIndex(s, -1) {
+		rule := r.headers[strings.ToLower(s[m[2]:m[3]])]
+		if rule == nil {
+			continue
+		}
+		if rule.Action == RedactDrop {
+			b.WriteString(s[last:m[0]])
+			last = m[1]
+			if strings.HasPrefix(s[last:], "\r\n") {
+				last += 2
+			} else if strings.HasPrefix(s[last:], "\n") {
+				last++
+			}
+			continue
+		}
+		b.WriteString(s[last:m[4]])
+		b.WriteString(r.replacement(rule, s[m[4]:m[5]]))
+		last = m[5]
+	}
+	if last == 0 {
+		return s
+	}
+	b.WriteString(s[last:])
+	return b.String()
+}
+
+// labels redacts labels in place.
+func (r *redactor) labels(labels map[string]string) {
+	for k, v := range labels {
+		if rule := r.keys[strings.ToLower(k)]; rule != nil {
+			if rule.Action == RedactDrop {
+				delete(labels, k)
+			} else {
+				labels[k] = r.replacement(rule, v)
+			}
+			continue
+		}
+		if nv, keep := r.text(v); keep {
+			labels[k] = nv
+		} else {
+			delete(labels, k)
+		}
+	}
+}
+
+// query redacts the parameters of a raw URL query, keeping their order.
+func (r *redactor) query(raw string) string {
+	if raw == "" {
+		return raw
+	}
+	params := strings.Split(raw, "&")
+	out := params[:0]
+	for _, p := range params {
+		k, v, _ := strings.Cut(p, "=")
+		name, err := url.QueryUnescape(k)
+		if err != nil {
+			name = k
+		}
+		val, err := url.QueryUnescape(v)
+		if err != nil {
+			val = v
+		}
+		if rule := r.keys[strings.ToLower(name)]; rule != nil {
+			if rule.Action != RedactDrop {
+				out = append(out, k+"="+url.QueryEscape(r.replacement(rule, val)))
+			}
+			continue
+		}
+		nv, keep := r.text(val)
+		if !keep {
+			continue
+		}
+		if nv != val {
+			p = k + "=" + url.QueryEscape(nv)
+		}
+		out = append(out, p)
+	}
+	return strings.Join(out, "&")
+}
+
+// httpRequest returns a copy of hr with the URL query, the Referer's query
+// and the headers redacted. The original request is left alone.
+func (r *redactor) httpRequest(hr *gcl.HTTPRequest) *gcl.HTTPRequest {
+	if hr == nil || hr.Request == nil {
+		return hr
+	}
+	redacted := *hr
+	req := hr.Request.Clone(hr.Request.Context())
+	if req.URL != nil {
+		req.URL.RawQuery = r.query(req.URL.RawQuery)
+	}
+	for name, values := range req.Header {
+		rule := r.headers[strings.ToLower(name)]
+		switch {
+		case rule == nil:
+		case rule.Action == RedactDrop:
+			delete(req.Header, name)
+		default:
+			for i, v := range values {
+				values[i] = r.replacement(rule, v)
+			}
+		}
+	}
+	if ref, err := url.Parse(req.Header.Get("Referer")); err == nil && ref.RawQuery != "" {
+		ref.RawQuery = r.query(ref.RawQuery)
+		req.Header.Set("Referer", ref.String())
+	}
+	redacted.Request = req
+	return &redacted
+}
+
+// redact scrubs the entry and its payload, which is modified in place.
+func (c *Core) redact(entry *gcl.Entry, payload map[string]interface{}) {
+	if c.redactor == nil {
+		return
+	}
+	c.redactor.fields(payload, payload, "")
+	c.redactor.labels(entry.Labels)
+	entry.HTTPRequest = c.redactor.httpRequest(entry.HTTPRequest)
+}
@@ -0,0 +1,132 @@
+package zapgcl
+
+import (
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var testRedaction = Redaction{
+	Rules: []RedactionRule{
+		{Keys: []string{"password", "token"}, Headers: []string{"Cookie"}, Action: RedactDrop},
+		{Paths: []string{"user.email"}, Values: []*regexp.Regexp{JWTPattern}, Headers: []string{"Authorization"}},
+		{Keys: []string{"session"}, Values: []*regexp.Regexp{EmailPattern}, Action: RedactHash},
+	},
+	HMACKey: []byte("key"),
+}
+
+func newRedactingCore(t *testing.T) (*Core, *testLogger) {
+	t.Helper()
+	tl := &testLogger{}
+	c, err := NewCore(nil, "", WithGoogleCloudLogger(tl), WithRedaction(testRedaction))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, tl
+}
+
+func TestRedactPayload(t *testing.T) {
+	c, tl := newRedactingCore(t)
+	jwt := "eyJhbGciOiJIUzI1NiJ9.eyJzdWIiOiIxIn0.c2ln"
+	context := []zapcore.Field{zap.Namespace("user"), zap.String("email", "a@example.com"), zap.String("Password", "x")}
+	l := zap.New(c).With(context...)
+
+	l.Info("bearer "+jwt,
+		zap.String("name", "bob"),
+		zap.Strings("sessions", []string{"contact bob@example.com"}),
+		zap.String("session", "abc"),
+	)
+	l.Info("again")
+
+	hash := newRedactor(&testRedaction).replacement(&testRedaction.Rules[2], "bob@example.com")
+	expected := map[string]interface{}{
+		"message": "bearer " + DefaultRedactionMask,
+		"user": map[string]interface{}{
+			"email":    DefaultRedactionMask,
+			"name":     "bob",
+			"sessions": []interface{}{"contact " + hash},
+			"session":  newRedactor(&testRedaction).replacement(&testRedaction.Rules[2], "abc"),
+		},
+	}
+	if diff := cmp.Diff(expected, tl.entries[0].Payload); diff != "" {
+		t.Error(diff)
+	}
+	// The context fields aren't modified.
+	expected = map[string]interface{}{"message": "again", "user": map[string]interface{}{"email": DefaultRedactionMask}}
+	if diff := cmp.Diff(expected, tl.entries[1].Payload); diff != "" {
+		t.Error(diff)
+	}
+	if len(hash) != 64 {
+		t.Errorf("unexpected hash %q", hash)
+	}
+}
+
+func TestRedactLabelsAndHTTPRequest(t *testing.T) {
+	c, tl := newRedactingCore(t)
+	req := httptest.NewRequest("GET", "http://example.com/x?token=secret&q=a%40example.com&page=2", nil)
+	req.Header.Set("Authorization", "Bearer secret")
+	req.Header.Set("Cookie", "sid=secret")
+	req.Header.Set("Referer", "http://example.com/login?token=secret")
+
+	zap.New(c).Info("request",
+		zap.String(LabelPrefix+"token", "secret"),
+		zap.String(LabelPrefix+"owner", "a@example.com"),
+		zap.String(LabelPrefix+"env", "prod"),
+		zap.Object(HTTPRequestKey, &HTTPExchange{Request: req, Status: 200, Latency: time.Second}),
+		zap.String("request", "GET /x HTTP/1.1\r\nHost: example.com\r\nAuthorization: Bearer secret\r\nCookie: sid=secret\r\n\r\n"),
+	)
+
+	e := tl.entries[0]
+	hashed := newRedactor(&testRedaction).replacement(&testRedaction.Rules[2], "a@example.com")
+	if diff := cmp.Diff(map[string]string{"owner": hashed, "env": "prod"}, e.Labels); diff != "" {
+		t.Error(diff)
+	}
+	r := e.HTTPRequest.Request
+	if r.URL.RawQuery != "q="+hashed+"&page=2" {
+		t.Errorf("unexpected query %q", r.URL.RawQuery)
+	}
+	if r.Header.Get("Authorization") != DefaultRedactionMask || r.Header.Get("Cookie") != "" {
+		t.Errorf("unexpected headers %v", r.Header)
+	}
+	if ref := r.Header.Get("Referer"); strings.Contains(ref, "secret") {
+		t.Errorf("unexpected referer %q", ref)
+	}
+	if req.URL.RawQuery == r.URL.RawQuery || req.Header.Get("Cookie") == "" {
+		t.Error("the original request was modified")
+	}
+
+	dump := e.Payload.(map[string]interface{})["request"]
+	expected := "GET /x HTTP/1.1\r\nHost: example.com\r\nAuthorization: " + DefaultRedactionMask + "\r\n\r\n"
+	if dump != expected {
+		t.Errorf("unexpected dump %q", dump)
+	}
+}
+
+func TestRedactBeforeTruncation(t *testing.T) {
+	red := Redaction{Rules: []RedactionRule{{Values: []*regexp.Regexp{EmailPattern}}}}
+	for _, policy := range []OversizePolicy{OversizeTruncate, OversizeSplit} {
+		// Wherever the cut falls, no part of the address gets through.
+		for offset := 2600; offset < 3400; offset += 5 {
+			l := &testLogger{}
+			c, err := NewCore(nil, "", WithGoogleCloudLogger(l), WithRedaction(red), WithOversizePolicy(policy, 4096))
+			if err != nil {
+				t.Fatal(err)
+			}
+			msg := strings.Repeat("x", offset) + " bob@example.com " + strings.Repeat("y", 5000)
+			if err := c.Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: msg}, nil); err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range l.entries {
+				if m := e.Payload.(map[string]interface{})["message"].(string); strings.Contains(m, "bob") || strings.Contains(m, "example.com") {
+					t.Fatalf("policy %d, offset %d: address leaked", policy, offset)
+				}
+			}
+		}
+	}
+}
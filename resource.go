@@ -0,0 +1,211 @@
+package zapgcl
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/compute/metadata"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+)
+
+// MetadataClient looks up values on the GCE metadata server. The suffix is
+// relative to "/computeMetadata/v1/". *metadata.Client implements it.
+type MetadataClient interface {
+	GetWithContext(ctx context.Context, suffix string) (string, error)
+}
+
+// HTTPMetadataClient is a MetadataClient which queries the metadata server at
+// BaseURL, such as "http://169.254.169.254". It's mainly useful for pointing
+// resource detection at a fake server.
+type HTTPMetadataClient struct {
+	BaseURL string
+
+	// Client is used to make requests; http.DefaultClient if nil.
+	Client *http.Client
+}
+
+// GetWithContext implements MetadataClient.
+func (m *HTTPMetadataClient) GetWithContext(ctx context.Context, suffix string) (string, error) {
+	u := strings.TrimRight(m.BaseURL, "/") + "/computeMetadata/v1/" + strings.TrimLeft(suffix, "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	hc := m.Client
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	res, err := hc.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return "", err
+	}
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata %q: %s", suffix, res.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// A ResourceDetector works out which MonitoredResource the process is running
+// as, from the environment variables set by Cloud Run, Cloud Functions, App
+// Engine and GKE (including the usual downward API variables) and from the
+// metadata server.
+type ResourceDetector struct {
+	// Getenv looks up environment variables; os.Getenv if nil.
+	Getenv func(string) string
+
+	// ReadFile reads files, such as the namespace of the service account
+	// mounted in GKE pods; os.ReadFile if nil.
+	ReadFile func(string) ([]byte, error)
+
+	// Metadata is used to query the metadata server. If nil, the default
+	// metadata client is used, but only when running on GCE.
+	Metadata MetadataClient
+
+	// Timeout bounds all of the metadata lookups together. Defaults to two
+	// seconds.
+	Timeout time.Duration
+}
+
+// DetectResource runs a ResourceDetector with default settings.
+func DetectResource() *mrpb.MonitoredResource {
+	return (&ResourceDetector{}).Detect()
+}
+
+// Detect returns the detected resource, or nil if the process doesn't seem to
+// be running on Google Cloud.
+func (d *ResourceDetector) Detect() *mrpb.MonitoredResource {
+	getenv := d.Getenv
+	if getenv == nil {
+		getenv = os.Getenv
+	}
+	readFile := d.ReadFile
+	if readFile == nil {
+		readFile = os.ReadFile
+	}
+	md := d.Metadata
+	if md == nil && metadata.OnGCE() {
+		md = metadata.NewClient(nil)
+	}
+	timeout := d.Timeout
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	lookup := func(suffix string) string {
+		if md == nil {
+			return ""
+		}
+		v, err := md.GetWithContext(ctx, suffix)
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(v)
+	}
+	// Zones and regions are returned as "projects/<n>/zones/<zone>".
+	last := func(s string) string { return s[strings.LastIndex(s, "/")+1:] }
+
+	projectID := lookup("project/project-id")
+	if projectID == "" {
+		projectID = projectIDFromEnv()
+	}
+
+	switch {
+	case getenv("FUNCTION_TARGET") != "":
+		name := getenv("K_SERVICE")
+		if name == "" {
+			name = getenv("FUNCTION_NAME")
+		}
+		region := getenv("FUNCTION_REGION")
+		if region == "" {
+			region = last(lookup("instance/region"))
+		}
+		return &mrpb.MonitoredResource{
+			Type: "cloud_function",
+			Labels: map[string]string{
+				"project_id":    projectID,
+				"function_name": name,
+				"region":        region,
+			},
+		}
+
+	case getenv("K_SERVICE") != "" && getenv("K_REVISION") != "":
+		return &mrpb.MonitoredResource{
+			Type: "cloud_run_revision",
+			Labels: map[string]string{
+				"project_id":         projectID,
+				"service_name":       getenv("K_SERVICE"),
+				"revision_name":      getenv("K_REVISION"),
+				"configuration_name": getenv("K_CONFIGURATION"),
+				"location":           last(lookup("instance/region")),
+			},
+		}
+
+	case getenv("GAE_SERVICE") != "":
+		return &mrpb.MonitoredResource{
+			Type: "gae_app",
+			Labels: map[string]string{
+				"project_id": projectID,
+				"module_id":  getenv("GAE_SERVICE"),
+				"version_id": getenv("GAE_VERSION"),
+				"zone":       last(lookup("instance/zone")),
+			},
+		}
+
+	case getenv("KUBERNETES_SERVICE_HOST") != "":
+		namespace := firstEnv(getenv, "NAMESPACE_NAME", "POD_NAMESPACE", "NAMESPACE")
+		if namespace == "" {
+			b, _ := readFile("/var/run/secrets/kubernetes.io/serviceaccount/namespace")
+			namespace = strings.TrimSpace(string(b))
+		}
+		return &mrpb.MonitoredResource{
+			Type: "k8s_container",
+			Labels: map[string]string{
+				"project_id":     projectID,
+				"location":       lookup("instance/attributes/cluster-location"),
+				"cluster_name":   lookup("instance/attributes/cluster-name"),
+				"namespace_name": namespace,
+				"pod_name":       firstEnv(getenv, "POD_NAME", "HOSTNAME"),
+				"container_name": firstEnv(getenv, "CONTAINER_NAME"),
+			},
+		}
+
+	case md != nil:
+		instanceID := lookup("instance/id")
+		if instanceID == "" {
+			return nil
+		}
+		return &mrpb.MonitoredResource{
+			Type: "gce_instance",
+			Labels: map[string]string{
+				"project_id":  projectID,
+				"instance_id": instanceID,
+				"zone":        last(lookup("instance/zone")),
+			},
+		}
+	}
+	return nil
+}
+
+// firstEnv returns the first non-empty variable of keys.
+func firstEnv(getenv func(string) string, keys ...string) string {
+	for _, k := range keys {
+		if v := getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,143 @@
+package zapgcl
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap/zapcore"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func newFakeMetadataServer(t *testing.T, values map[string]string) *HTTPMetadataClient {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing Metadata-Flavor", http.StatusForbidden)
+			return
+		}
+		v, ok := values[strings.TrimPrefix(r.URL.Path, "/computeMetadata/v1/")]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(v))
+	}))
+	t.Cleanup(srv.Close)
+	return &HTTPMetadataClient{BaseURL: srv.URL}
+}
+
+func TestResourceDetector(t *testing.T) {
+	md := newFakeMetadataServer(t, map[string]string{
+		"project/project-id":                   "proj",
+		"instance/id":                          "1234",
+		"instance/zone":                        "projects/1/zones/us-central1-a",
+		"instance/region":                      "projects/1/regions/us-central1",
+		"instance/attributes/cluster-name":     "cluster",
+		"instance/attributes/cluster-location": "us-central1",
+	})
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		files    map[string]string
+		md       MetadataClient
+		expected *mrpb.MonitoredResource
+	}{
+		{
+			name: "cloud run",
+			env:  map[string]string{"K_SERVICE": "svc", "K_REVISION": "svc-1", "K_CONFIGURATION": "svc"},
+			md:   md,
+			expected: &mrpb.MonitoredResource{Type: "cloud_run_revision", Labels: map[string]string{
+				"project_id": "proj", "service_name": "svc", "revision_name": "svc-1",
+				"configuration_name": "svc", "location": "us-central1",
+			}},
+		},
+		{
+			name: "cloud functions",
+			env:  map[string]string{"FUNCTION_TARGET": "Handle", "K_SERVICE": "fn", "K_REVISION": "fn-1"},
+			md:   md,
+			expected: &mrpb.MonitoredResource{Type: "cloud_function", Labels: map[string]string{
+				"project_id": "proj", "function_name": "fn", "region": "us-central1",
+			}},
+		},
+		{
+			name: "app engine",
+			env:  map[string]string{"GAE_SERVICE": "default", "GAE_VERSION": "v1"},
+			md:   md,
+			expected: &mrpb.MonitoredResource{Type: "gae_app", Labels: map[string]string{
+				"project_id": "proj", "module_id": "default", "version_id": "v1", "zone": "us-central1-a",
+			}},
+		},
+		{
+			name: "gke",
+			env: map[string]string{
+				"KUBERNETES_SERVICE_HOST": "10.0.0.1", "POD_NAMESPACE": "ns",
+				"POD_NAME": "pod-abc", "CONTAINER_NAME": "app",
+			},
+			md: md,
+			expected: &mrpb.MonitoredResource{Type: "k8s_container", Labels: map[string]string{
+				"project_id": "proj", "location": "us-central1", "cluster_name": "cluster",
+				"namespace_name": "ns", "pod_name": "pod-abc", "container_name": "app",
+			}},
+		},
+		{
+			name: "gke service account namespace",
+			env: map[string]string{
+				"KUBERNETES_SERVICE_HOST": "10.0.0.1", "HOSTNAME": "pod-abc",
+			},
+			files: map[string]string{
+				"/var/run/secrets/kubernetes.io/serviceaccount/namespace": "ns\n",
+			},
+			md: md,
+			expected: &mrpb.MonitoredResource{Type: "k8s_container", Labels: map[string]string{
+				"project_id": "proj", "location": "us-central1", "cluster_name": "cluster",
+				"namespace_name": "ns", "pod_name": "pod-abc", "container_name": "",
+			}},
+		},
+		{
+			name: "gce",
+			md:   md,
+			expected: &mrpb.MonitoredResource{Type: "gce_instance", Labels: map[string]string{
+				"project_id": "proj", "instance_id": "1234", "zone": "us-central1-a",
+			}},
+		},
+		{
+			name: "not on gcp",
+			md:   newFakeMetadataServer(t, nil),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &ResourceDetector{
+				Getenv: func(k string) string { return tt.env[k] },
+				ReadFile: func(name string) ([]byte, error) {
+					v, ok := tt.files[name]
+					if !ok {
+						return nil, os.ErrNotExist
+					}
+					return []byte(v), nil
+				},
+				Metadata: tt.md,
+			}
+			if diff := cmp.Diff(tt.expected, d.Detect(), protocmp.Transform()); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestCoreResourceOverride(t *testing.T) {
+	l := &testLogger{}
+	res := &mrpb.MonitoredResource{Type: "generic_task", Labels: map[string]string{"job": "j"}}
+	c := (&Core{Logger: l, Resource: res}).With(nil)
+	if err := c.Write(zapcore.Entry{Message: "hello"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if l.entries[0].Resource != res {
+		t.Error("per-Core resource not set on the entry")
+	}
+}
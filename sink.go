@@ -0,0 +1,311 @@
+package zapgcl
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	gcl "cloud.google.com/go/logging"
+	"github.com/blendle/zapdriver"
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+)
+
+// SinkScheme is the URL scheme of the zap sink this package registers, so that
+// a zap.Config can write to Cloud Logging with an output path such as
+//
+//	gcl://my-project/my-log?labels=env:prod,team:core&resource=auto
+//
+// The labels parameter, which may be repeated, sets common labels. The
+// resource parameter is "auto" for WithResourceDetection or a resource type,
+// such as "global", for WithResource; without it, the GCL client detects the
+// resource.
+//
+// The sink turns the JSON written by zap's JSON encoder, with the keys of
+// zap.NewProductionEncoderConfig or zap.NewDevelopmentEncoderConfig, or by the
+// EncoderName encoder, back into entries and writes them with a Core, so they
+// are mapped as any other. Other encodings are written as plain messages.
+// Clients are created once per project, using Application Default
+// Credentials, and never closed. The sink is flushed by Sync, which zap also
+// calls before exiting on Fatal entries.
+const SinkScheme = "gcl"
+
+func init() {
+	if err := zap.RegisterSink(SinkScheme, newSink); err != nil {
+		panic(err)
+	}
+}
+
+var (
+	// newSinkClient creates the clients of sinks; it's replaced in tests.
+	newSinkClient = newClient
+
+	sinkClientsMu sync.Mutex
+	sinkClients   = map[string]*gcl.Client{}
+)
+
+// sinkClient returns the client of projectID, creating it if necessary.
+func sinkClient(projectID string) (*gcl.Client, error) {
+	sinkClientsMu.Lock()
+	defer sinkClientsMu.Unlock()
+	if c, ok := sinkClients[projectID]; ok {
+		return c, nil
+	}
+	c, err := newSinkClient(projectID)
+	if err != nil {
+		return nil, err
+	}
+	sinkClients[projectID] = c
+	return c, nil
+}
+
+// sinkOptions parses the options of a sink URL.
+func sinkOptions(u *url.URL) (projectID, logID string, opts []Option, err error) {
+	projectID = u.Host
+	logID = strings.Trim(u.Path, "/")
+	if projectID == "" || logID == "" {
+		return "", "", nil, newError("sink URL %q must be of the form %s://<project>/<log ID>", u, SinkScheme)
+	}
+
+	q := u.Query()
+	labels := make(map[string]string)
+	for _, v := range q["labels"] {
+		if err := parseLabels(v, labels); err != nil {
+			return "", "", nil, newError("sink URL %q: %v", u, err)
+		}
+	}
+	opts = []Option{WithProjectID(projectID), WithLevel(zapcore.DebugLevel)}
+	if len(labels) > 0 {
+		opts = append(opts, WithCommonLabels(labels))
+	}
+	if res := resourceOption(q.Get("resource"), nil); res != nil {
+		opts = append(opts, res)
+	}
+	return projectID, logID, opts, nil
+}
+
+// parseLabels adds the labels of s, of the form "key:value,key:value", to
+// labels.
+func parseLabels(s string, labels map[string]string) error {
+	for _, kv := range strings.Split(s, ",") {
+		if kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, ":")
+		if !ok || k == "" {
+			return fmt.Errorf("label %q must be of the form key:value", kv)
+		}
+		labels[k] = v
+	}
+	return nil
+}
+
+// resourceOption returns the Option for a resource given as "auto", for
+// WithResourceDetection, or as a resource type with its labels, for
+// WithResource. It returns nil if res is empty.
+func resourceOption(res string, labels map[string]string) Option {
+	switch res {
+	case "":
+		return nil
+	case "auto":
+		return WithResourceDetection(nil)
+	}
+	return WithResource(&mrpb.MonitoredResource{Type: res, Labels: labels})
+}
+
+func newSink(u *url.URL) (zap.Sink, error) {
+	projectID, logID, opts, err := sinkOptions(u)
+	if err != nil {
+		return nil, err
+	}
+	client, err := sinkClient(projectID)
+	if err != nil {
+		return nil, newError("creating Google Logging client: %v", err)
+	}
+	c, err := NewCore(client, logID, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &sink{core: c}, nil
+}
+
+// sink is a zap.Sink writing to a Core.
+type sink struct {
+	core *Core
+}
+
+// Write implements zap.Sink. p holds one or more encoded entries.
+func (s *sink) Write(p []byte) (int, error) {
+	var err error
+	for _, line := range bytes.Split(p, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		ze, fields := decodeEntry(line)
+		err = multierr.Append(err, s.core.Write(ze, fields))
+	}
+	return len(p), err
+}
+
+// Sync implements zap.Sink.
+func (s *sink) Sync() error {
+	return s.core.Sync()
+}
+
+// Close implements zap.Sink. The client is shared, so it's only flushed.
+func (s *sink) Close() error {
+	return s.core.Sync()
+}
+
+// decodeEntry turns an encoded entry back into a zapcore.Entry and its fields.
+func decodeEntry(line []byte) (zapcore.Entry, []zapcore.Field) {
+	ze := zapcore.Entry{Level: zapcore.InfoLevel}
+
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	var m map[string]interface{}
+	if err := dec.Decode(&m); err != nil {
+		ze.Time = time.Now()
+		ze.Message = string(bytes.TrimSpace(line))
+		return ze, nil
+	}
+
+	var fields []zapcore.Field
+	for k, v := range m {
+		s, isString := v.(string)
+		switch {
+		case k == "level" && isString:
+			if ze.Level.UnmarshalText([]byte(s)) != nil {
+				ze.Level = zapcore.InfoLevel
+			}
+		case k == severityKey && isString:
+			ze.Level = levelForSeverity(gcl.ParseSeverity(s))
+		case k == "ts" || k == timestampKey:
+			ze.Time = decodeTime(v)
+		case (k == "msg" || k == "message") && isString:
+			ze.Message = s
+		case k == "logger" && isString:
+			ze.LoggerName = s
+		case k == "caller" && isString:
+			ze.Caller = decodeCaller(s)
+		case k == "function" && isString:
+			ze.Caller.Function = s
+		case k == sourceLocationKey:
+			if loc, ok := v.(map[string]interface{}); ok {
+				ze.Caller = decodeSourceLocation(loc)
+			}
+		case (k == "stacktrace" || k == "stack") && isString:
+			ze.Stack = s
+		case k == HTTPRequestKey:
+			if p, ok := decodeHTTPPayload(v); ok {
+				fields = append(fields, zap.Object(k, p))
+				continue
+			}
+			fields = append(fields, zap.Any(k, v))
+		case isNumber(v):
+			// zap.Any would encode a json.Number as a string.
+			fields = append(fields, numberField(k, v.(json.Number)))
+		default:
+			fields = append(fields, zap.Any(k, v))
+		}
+	}
+	if ze.Time.IsZero() {
+		ze.Time = time.Now()
+	}
+	return ze, fields
+}
+
+func isNumber(v interface{}) bool {
+	_, ok := v.(json.Number)
+	return ok
+}
+
+// numberField returns a field holding n as an integer if it is one, and as a
+// float otherwise.
+func numberField(k string, n json.Number) zapcore.Field {
+	if i, err := n.Int64(); err == nil {
+		return zap.Int64(k, i)
+	}
+	f, _ := n.Float64()
+	return zap.Float64(k, f)
+}
+
+// levelForSeverity returns the highest level DefaultSeverityMapping maps to
+// sev or a lower severity.
+func levelForSeverity(sev gcl.Severity) zapcore.Level {
+	level, best := zapcore.InfoLevel, gcl.Severity(-1)
+	for l := zapcore.DebugLevel; l <= zapcore.FatalLevel; l++ {
+		if s := DefaultSeverityMapping[l]; s <= sev && s > best {
+			level, best = l, s
+		}
+	}
+	return level
+}
+
+// decodeTime parses the epoch seconds or the ISO8601 / RFC3339 time zap and
+// the structured JSON format write.
+func decodeTime(v interface{}) time.Time {
+	switch t := v.(type) {
+	case json.Number:
+		if f, err := t.Float64(); err == nil {
+			sec := int64(f)
+			return time.Unix(sec, int64((f-float64(sec))*1e9))
+		}
+	case string:
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.000Z0700"} {
+			if ts, err := time.Parse(layout, t); err == nil {
+				return ts
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// decodeCaller parses a caller encoded as "file:line".
+func decodeCaller(s string) zapcore.EntryCaller {
+	i := strings.LastIndexByte(s, ':')
+	if i < 0 {
+		return zapcore.EntryCaller{Defined: true, File: s}
+	}
+	line, _ := strconv.Atoi(s[i+1:])
+	return zapcore.EntryCaller{Defined: true, File: s[:i], Line: line}
+}
+
+// decodeSourceLocation parses a LogEntrySourceLocation encoded as JSON.
+func decodeSourceLocation(loc map[string]interface{}) zapcore.EntryCaller {
+	c := zapcore.EntryCaller{Defined: true}
+	c.File, _ = loc["file"].(string)
+	c.Function, _ = loc["function"].(string)
+	switch line := loc["line"].(type) {
+	case string:
+		c.Line, _ = strconv.Atoi(line)
+	case json.Number:
+		n, _ := line.Int64()
+		c.Line = int(n)
+	}
+	return c
+}
+
+// decodeHTTPPayload parses an HttpRequest encoded as JSON, which is also the
+// encoding of zapdriver's HTTPPayload.
+func decodeHTTPPayload(v interface{}) (*zapdriver.HTTPPayload, bool) {
+	if _, ok := v.(map[string]interface{}); !ok {
+		return nil, false
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, false
+	}
+	var p zapdriver.HTTPPayload
+	if err := json.Unmarshal(b, &p); err != nil || p.RequestURL == "" {
+		return nil, false
+	}
+	return &p, true
+}
@@ -0,0 +1,151 @@
+package zapgcl
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	gcl "cloud.google.com/go/logging"
+	"cloud.google.com/go/logging/apiv2/loggingpb"
+	"github.com/blendle/zapdriver"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/api/option"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func TestSinkOptions(t *testing.T) {
+	for _, tc := range []struct {
+		url     string
+		project string
+		logID   string
+		opts    int
+		wantErr bool
+	}{
+		{url: "gcl://proj/log", project: "proj", logID: "log", opts: 2},
+		{url: "gcl://proj/log?labels=env:prod,team:core&resource=auto", project: "proj", logID: "log", opts: 4},
+		{url: "gcl://proj/log?labels=env:prod&labels=a:b&resource=global", project: "proj", logID: "log", opts: 4},
+		{url: "gcl://proj", wantErr: true},
+		{url: "gcl:///log", wantErr: true},
+		{url: "gcl://proj/log?labels=novalue", wantErr: true},
+	} {
+		u, err := url.Parse(tc.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		project, logID, opts, err := sinkOptions(u)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: unexpected error %v", tc.url, err)
+			continue
+		}
+		if project != tc.project || logID != tc.logID || len(opts) != tc.opts {
+			t.Errorf("%s: got %q, %q and %d options", tc.url, project, logID, len(opts))
+		}
+	}
+}
+
+// sinkEntries writes an entry with l, built by newLogger around a sink, and
+// returns what the sink wrote.
+func sinkEntries(t *testing.T, enc zapcore.Encoder, write func(l *zap.Logger)) []gcl.Entry {
+	t.Helper()
+	tl := &testLogger{}
+	s := &sink{core: &Core{Logger: tl, ProjectID: "proj", SeverityMapping: DefaultSeverityMapping}}
+	l := zap.New(zapcore.NewCore(enc, s, zapcore.DebugLevel), zap.AddCaller())
+	write(l)
+	return tl.entries
+}
+
+func TestSinkDecodesZapJSON(t *testing.T) {
+	req := httptest.NewRequest("POST", "http://example.com/x", nil)
+	entries := sinkEntries(t, zapcore.NewJSONEncoder(zap.NewProductionEncoderConfig()), func(l *zap.Logger) {
+		l.Named("svc").Warn("hello",
+			zap.String(TraceKey, "abc"),
+			zap.String(LabelPrefix+"env", "test"),
+			zap.Int("n", 3),
+			zap.Object(HTTPRequestKey, &HTTPExchange{Request: req, Status: 201, Latency: time.Second}),
+		)
+	})
+	if len(entries) != 1 {
+		t.Fatalf("expected one entry, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.Severity != gcl.Warning || e.Trace != "projects/proj/traces/abc" || e.Labels["env"] != "test" {
+		t.Errorf("unexpected entry %+v", e)
+	}
+	if e.SourceLocation == nil || e.SourceLocation.Line == 0 {
+		t.Errorf("missing source location: %v", e.SourceLocation)
+	}
+	if e.HTTPRequest == nil || e.HTTPRequest.Status != 201 || e.HTTPRequest.Latency != time.Second {
+		t.Errorf("unexpected HTTP request %+v", e.HTTPRequest)
+	}
+	expected := map[string]interface{}{"message": "hello", "logger": "svc", "n": int64(3)}
+	if diff := cmp.Diff(expected, e.Payload); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestSinkRoundTripsStructuredJSON(t *testing.T) {
+	t.Setenv("GOOGLE_CLOUD_PROJECT", "proj")
+	write := func(l *zap.Logger) {
+		l.Error("boom",
+			zap.String(TraceKey, "abc"),
+			zap.String(LabelPrefix+"env", "test"),
+			zapdriver.Operation("id", "producer", true, false),
+		)
+	}
+
+	direct := &testLogger{}
+	zap.New(&Core{Logger: direct, ProjectID: "proj", SeverityMapping: DefaultSeverityMapping}, zap.AddCaller()).
+		Error("boom", zap.String(TraceKey, "abc"), zap.String(LabelPrefix+"env", "test"), zapdriver.Operation("id", "producer", true, false))
+	viaSink := sinkEntries(t, NewJSONEncoder(zapcore.EncoderConfig{}), write)
+
+	if len(viaSink) != 1 {
+		t.Fatalf("expected one entry, got %d", len(viaSink))
+	}
+	ignore := []cmp.Option{
+		protocmp.Transform(),
+		cmpopts.IgnoreFields(gcl.Entry{}, "Timestamp"),
+		// The callers differ.
+		protocmp.IgnoreFields(&loggingpb.LogEntrySourceLocation{}, "line", "function"),
+	}
+	if diff := cmp.Diff(direct.entries, viaSink, ignore...); diff != "" {
+		t.Error(diff)
+	}
+	if viaSink[0].Timestamp.IsZero() {
+		t.Error("missing timestamp")
+	}
+}
+
+func TestSinkRegistered(t *testing.T) {
+	created := 0
+	newSinkClient = func(projectID string) (*gcl.Client, error) {
+		created++
+		client, err := gcl.NewClient(context.Background(), projectID,
+			option.WithoutAuthentication(), option.WithEndpoint("localhost:0"))
+		if err == nil {
+			client.OnError = func(error) {}
+		}
+		return client, err
+	}
+	defer func() {
+		newSinkClient = newClient
+		sinkClientsMu.Lock()
+		delete(sinkClients, "sink-test")
+		sinkClientsMu.Unlock()
+	}()
+
+	for i := 0; i < 2; i++ {
+		_, closeSink, err := zap.Open("gcl://sink-test/log?labels=env:prod&resource=global")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer closeSink()
+	}
+	if created != 1 {
+		t.Errorf("expected the client to be shared, created %d", created)
+	}
+}
@@ -0,0 +1,418 @@
+package zapgcl
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	gcl "cloud.google.com/go/logging"
+	"cloud.google.com/go/logging/apiv2/loggingpb"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	// DefaultMaxEntrySize is the estimated encoded size above which entries
+	// are considered oversized. Cloud Logging rejects entries over 256KiB;
+	// the difference leaves room for the estimate being off.
+	DefaultMaxEntrySize = 240 * 1024
+
+	// TruncatedKey is the payload field set to true on entries whose string
+	// fields were truncated to make them fit.
+	TruncatedKey = "truncated"
+
+	// SplitKey is the payload field holding the uid, index and totalSplits
+	// of entries which were split to make them fit, unless
+	// SplitEntriesClientOption has made it their LogEntry.split.
+	SplitKey = "split"
+
+	// entryOverhead accounts for the parts of an entry which aren't
+	// estimated individually: its log name, resource, timestamp and so on.
+	entryOverhead = 1024
+
+	// truncatedSuffix is appended to truncated strings.
+	truncatedSuffix = "…(truncated)"
+
+	// minTruncatedLen is how much of a string is always kept, so that a
+	// truncated field still says what it was about.
+	minTruncatedLen = 128
+
+	// maxSplits caps the number of entries an entry is split into; what
+	// doesn't fit in them is truncated.
+	maxSplits = 100
+)
+
+// An OversizePolicy decides what a Core does with entries which would be too
+// large for Cloud Logging, which otherwise get dropped when they are sent.
+type OversizePolicy int
+
+const (
+	// OversizeTruncate shortens the largest string fields of the entry,
+	// marks them with a "…(truncated)" suffix and sets the TruncatedKey
+	// field.
+	OversizeTruncate OversizePolicy = iota
+
+	// OversizeSplit cuts the largest string field of the entry into pieces
+	// and writes one entry for each, linked by a SplitKey field shaped like
+	// LogEntry.split, since gcl.Entry has no way to set the LogEntry field
+	// itself. Other fields are repeated in every piece, and truncated if
+	// they don't leave room for the split one.
+	//
+	// Clients created with SplitEntriesClientOption, as NewDevelopment and
+	// NewProduction create theirs, move the SplitKey field into
+	// LogEntry.split, so that Cloud Logging groups the pieces. Written by
+	// other clients, or as structured JSON, the pieces are not native split
+	// entries: they are only linked by their SplitKey field.
+	OversizeSplit
+)
+
+// WithOversizePolicy sets what happens to entries whose estimated encoded size
+// is over maxSize bytes. A maxSize of zero means DefaultMaxEntrySize, and a
+// negative one disables the check. The default is OversizeTruncate at
+// DefaultMaxEntrySize.
+func WithOversizePolicy(p OversizePolicy, maxSize int) Option {
+	return optionFunc(func(o *options) {
+		o.oversizePolicy = p
+		o.maxEntrySize = maxSize
+	})
+}
+
+// stringLeaf is a string somewhere in a payload, identified by the map keys
+// and slice indices leading to it.
+type stringLeaf struct {
+	path []interface{}
+	s    string
+}
+
+// stringLeaves returns the strings in v, largest first.
+func stringLeaves(v interface{}) []stringLeaf {
+	var leaves []stringLeaf
+	var walk func(v interface{}, path []interface{})
+	walk = func(v interface{}, path []interface{}) {
+		switch v := v.(type) {
+		case string:
+			leaves = append(leaves, stringLeaf{path: append([]interface{}(nil), path...), s: v})
+		case map[string]interface{}:
+			for k, e := range v {
+				walk(e, append(path, k))
+			}
+		case []interface{}:
+			for i, e := range v {
+				walk(e, append(path, i))
+			}
+		}
+	}
+	walk(v, nil)
+	sort.SliceStable(leaves, func(i, j int) bool { return len(leaves[i].s) > len(leaves[j].s) })
+	return leaves
+}
+
+// samePath reports whether two stringLeaf paths are the same.
+func samePath(a, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// setPath returns v with the value at path replaced by x. Maps and slices
+// along the path are copied, since they may be shared with a parent Core.
+func setPath(v interface{}, path []interface{}, x interface{}) interface{} {
+	if len(path) == 0 {
+		return x
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			m[k] = e
+		}
+		k := path[0].(string)
+		m[k] = setPath(v[k], path[1:], x)
+		return m
+	case []interface{}:
+		s := append([]interface{}(nil), v...)
+		i := path[0].(int)
+		s[i] = setPath(v[i], path[1:], x)
+		return s
+	}
+	return v
+}
+
+// setLeaf replaces the value at path in payload, which is not shared, so
+// it's modified in place.
+func setLeaf(payload map[string]interface{}, path []interface{}, x interface{}) {
+	k := path[0].(string)
+	payload[k] = setPath(payload[k], path[1:], x)
+}
+
+// valueSize estimates the size of v encoded as JSON. String escaping is not
+// taken into account.
+func valueSize(v interface{}) int {
+	switch v := v.(type) {
+	case nil:
+		return 4
+	case string:
+		return len(v) + 2
+	case json.Number:
+		return len(v)
+	case bool:
+		return 5
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, uintptr, float32, float64:
+		return 20
+	case time.Time:
+		return 32
+	case map[string]interface{}:
+		n := 2
+		for k, e := range v {
+			n += len(k) + 4 + valueSize(e)
+		}
+		return n
+	case []interface{}:
+		n := 2
+		for _, e := range v {
+			n += valueSize(e) + 1
+		}
+		return n
+	}
+	// Values stored as they were given, such as fields built by hand.
+	var b []byte
+	if err := safeMarshal(func() (err error) {
+		b, err = json.Marshal(v)
+		return err
+	}); err != nil {
+		return 0
+	}
+	return len(b)
+}
+
+// entrySize estimates the encoded size of entry, whose payload is payload.
+func entrySize(entry *gcl.Entry, payload map[string]interface{}) int {
+	n := entryOverhead + valueSize(payload)
+	for k, v := range entry.Labels {
+		n += len(k) + len(v) + 6
+	}
+	if r := entry.HTTPRequest; r != nil && r.Request != nil {
+		n += len(r.Request.URL.String()) + len(r.Request.UserAgent()) + len(r.Request.Referer())
+	}
+	return n + len(entry.Trace) + len(entry.SpanID) + len(entry.InsertID)
+}
+
+// truncateString cuts s down by at least n bytes, on a rune boundary, and
+// marks it as truncated. It never keeps less than minTruncatedLen bytes.
+func truncateString(s string, n int) string {
+	keep := len(s) - n - len(truncatedSuffix)
+	if keep < minTruncatedLen {
+		keep = minTruncatedLen
+	}
+	if keep >= len(s) {
+		return s
+	}
+	for keep > 0 && !utf8.RuneStart(s[keep]) {
+		keep--
+	}
+	return s[:keep] + truncatedSuffix
+}
+
+// truncate shortens the largest strings of payload, other than the one at
+// skip, until it has shrunk by excess bytes or there's nothing left to cut.
+// It reports whether anything was truncated.
+func truncate(payload map[string]interface{}, excess int, skip []interface{}) bool {
+	truncated := false
+	excess += valueSize(map[string]interface{}{TruncatedKey: true})
+	for _, leaf := range stringLeaves(payload) {
+		if excess <= 0 {
+			break
+		}
+		if skip != nil && samePath(leaf.path, skip) {
+			continue
+		}
+		s := truncateString(leaf.s, excess)
+		if len(s) >= len(leaf.s) {
+			// The rest are even shorter.
+			break
+		}
+		setLeaf(payload, leaf.path, s)
+		excess -= len(leaf.s) - len(s)
+		truncated = true
+	}
+	if truncated {
+		payload[TruncatedKey] = true
+	}
+	return truncated
+}
+
+// splitString cuts s into pieces of at most n bytes, on rune boundaries.
+func splitString(s string, n int) []string {
+	var pieces []string
+	for len(s) > n {
+		i := n
+		for i > 0 && !utf8.RuneStart(s[i]) {
+			i--
+		}
+		if i == 0 {
+			i = n
+		}
+		pieces = append(pieces, s[:i])
+		s = s[i:]
+	}
+	return append(pieces, s)
+}
+
+// splitUID returns an identifier for a sequence of split entries.
+func splitUID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b)
+}
+
+// maxEntrySize returns the size above which entries are oversized, or a
+// negative number if they are never considered to be.
+func (c *Core) maxEntrySize() int {
+	if c.MaxEntrySize == 0 {
+		return DefaultMaxEntrySize
+	}
+	return c.MaxEntrySize
+}
+
+// fit applies the Oversize policy to entry, whose payload is payload, and
+// returns the entries to write in its place.
+func (c *Core) fit(entry gcl.Entry, payload map[string]interface{}) []gcl.Entry {
+	limit := c.maxEntrySize()
+	if limit < 0 {
+		return []gcl.Entry{entry}
+	}
+	size := entrySize(&entry, payload)
+	if size <= limit {
+		return []gcl.Entry{entry}
+	}
+
+	if c.Oversize == OversizeSplit {
+		if entries := c.split(entry, payload, size, limit); entries != nil {
+			return entries
+		}
+	}
+	truncate(payload, size-limit, nil)
+	return []gcl.Entry{entry}
+}
+
+// split implements OversizeSplit. It returns nil if the entry has no string
+// worth splitting.
+func (c *Core) split(entry gcl.Entry, payload map[string]interface{}, size, limit int) []gcl.Entry {
+	leaves := stringLeaves(payload)
+	if len(leaves) == 0 {
+		return nil
+	}
+	largest := leaves[0]
+
+	// Make sure at least half of each piece is left for the split string.
+	splitOverhead := valueSize(map[string]interface{}{
+		SplitKey: map[string]interface{}{"uid": splitUID(), "index": 0, "totalSplits": 0},
+	}) + len(truncatedSuffix)
+	rest := size - len(largest.s) + splitOverhead
+	if rest > limit/2 {
+		truncate(payload, rest-limit/2, largest.path)
+		rest = entrySize(&entry, payload) - len(largest.s) + splitOverhead
+	}
+	chunk := limit - rest
+	if chunk <= 0 {
+		return nil
+	}
+
+	s := largest.s
+	if len(s) > chunk*maxSplits {
+		s = truncateString(s, len(s)-chunk*maxSplits+len(truncatedSuffix))
+		payload[TruncatedKey] = true
+	}
+	pieces := splitString(s, chunk)
+	if len(pieces) == 1 {
+		setLeaf(payload, largest.path, s)
+		return []gcl.Entry{entry}
+	}
+
+	uid := entry.InsertID
+	if uid == "" {
+		uid = splitUID()
+	}
+	entries := make([]gcl.Entry, len(pieces))
+	for i, piece := range pieces {
+		p := make(map[string]interface{}, len(payload)+1)
+		for k, v := range payload {
+			p[k] = v
+		}
+		setLeaf(p, largest.path, piece)
+		p[SplitKey] = map[string]interface{}{
+			"uid":         uid,
+			"index":       i,
+			"totalSplits": len(pieces),
+		}
+
+		e := entry
+		e.Payload = p
+		if entry.InsertID != "" {
+			e.InsertID = entry.InsertID + "-" + strconv.Itoa(i)
+		}
+		entries[i] = e
+	}
+	return entries
+}
+
+// SplitEntriesClientOption returns an option for gcl.NewClient which makes
+// the SplitKey field of the entries written by OversizeSplit their
+// LogEntry.split, so that Cloud Logging knows them for the pieces of one
+// entry. NewDevelopment and NewProduction use it.
+func SplitEntriesClientOption() option.ClientOption {
+	return option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(nativeSplits))
+}
+
+// nativeSplits is a grpc.UnaryClientInterceptor which moves the SplitKey
+// field of the entries of WriteLogEntries calls to their LogEntry.split.
+func nativeSplits(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	if wr, ok := req.(*loggingpb.WriteLogEntriesRequest); ok && method == writeLogEntriesMethod {
+		for _, e := range wr.GetEntries() {
+			moveSplit(e)
+		}
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+// moveSplit moves the SplitKey field of the jsonPayload of e to its
+// LogEntry.split, if it has the shape split sets.
+func moveSplit(e *loggingpb.LogEntry) {
+	fields := e.GetJsonPayload().GetFields()
+	split := fields[SplitKey].GetStructValue().GetFields()
+	if len(split) != 3 || e.GetSplit() != nil {
+		return
+	}
+	uid, ok := split["uid"].GetKind().(*structpb.Value_StringValue)
+	if !ok {
+		return
+	}
+	index, ok := split["index"].GetKind().(*structpb.Value_NumberValue)
+	if !ok {
+		return
+	}
+	total, ok := split["totalSplits"].GetKind().(*structpb.Value_NumberValue)
+	if !ok {
+		return
+	}
+	e.Split = &loggingpb.LogSplit{
+		Uid:         uid.StringValue,
+		Index:       int32(index.NumberValue),
+		TotalSplits: int32(total.NumberValue),
+	}
+	delete(fields, SplitKey)
+}
@@ -0,0 +1,282 @@
+package zapgcl
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	gcl "cloud.google.com/go/logging"
+	"go.uber.org/zap"
+	logtypepb "google.golang.org/genproto/googleapis/logging/type"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// Keys of the structured JSON format which aren't payload field keys already.
+const (
+	severityKey       = "severity"
+	timestampKey      = "timestamp"
+	sourceLocationKey = "logging.googleapis.com/sourceLocation"
+)
+
+// MovedKeyPrefix is prepended by JSONLogger to the keys of payload fields
+// which the logging agents would take for a LogEntry field rather than part
+// of the jsonPayload, such as "severity", "time" or
+// "logging.googleapis.com/trace". The API transport writes them to the
+// jsonPayload as they are.
+const MovedKeyPrefix = "payload."
+
+// isEnvelopeKey reports whether the logging agents read the top level field
+// k of the structured JSON format into a LogEntry field.
+func isEnvelopeKey(k string) bool {
+	switch k {
+	case severityKey, timestampKey, "time", "timestampSeconds", "timestampNanos", HTTPRequestKey:
+		return true
+	}
+	return strings.HasPrefix(k, "logging.googleapis.com/")
+}
+
+// A Transport is how the Logger built by NewAuto sends entries to Cloud
+// Logging.
+type Transport int
+
+const (
+	// TransportAuto writes to standard output on Cloud Run and Cloud
+	// Functions, where the platform forwards it to Cloud Logging, and to the
+	// API elsewhere. If the API client can't be created, for example
+	// because there are no credentials, it falls back to standard output.
+	TransportAuto Transport = iota
+
+	// TransportAPI always writes to the Cloud Logging API.
+	TransportAPI
+
+	// TransportStdout always writes to standard output.
+	TransportStdout
+)
+
+// WithTransport sets how NewAuto sends entries. The default is TransportAuto.
+func WithTransport(t Transport) Option {
+	return optionFunc(func(o *options) {
+		o.transport = t
+	})
+}
+
+// WithStructuredOutput sets where NewAuto writes structured JSON when it
+// doesn't use the API. The default is os.Stdout.
+func WithStructuredOutput(w io.Writer) Option {
+	return optionFunc(func(o *options) {
+		o.structuredOutput = w
+	})
+}
+
+// onServerless reports whether the process runs on Cloud Run or Cloud
+// Functions, which forward standard output to Cloud Logging.
+func onServerless() bool {
+	return os.Getenv("K_SERVICE") != "" || os.Getenv("FUNCTION_TARGET") != ""
+}
+
+// NewAuto builds a Logger configured by cfg which writes to Cloud Logging
+// with the given Transport. If projectID is empty, it's taken from the
+// environment.
+//
+// When writing to the API, the Logger is built like NewWithOptions does.
+// Otherwise, entries are written to standard output as the structured JSON
+// the Cloud Logging agents understand (see JSONLogger), instead of in the
+// format of cfg, and a warning says why if the API was given up on. Either
+// way, entries are shaped by the same Core. The Closer closes the client, if
+// one was created.
+func NewAuto(cfg zap.Config, projectID string, logID string, opts ...Option) (*zap.Logger, *Closer, error) {
+	o := newOptions(opts)
+	t := o.transport
+	if t == TransportAuto && onServerless() {
+		t = TransportStdout
+	}
+	if projectID == "" {
+		projectID = projectIDFromEnv()
+	}
+
+	var apiErr error
+	if t != TransportStdout {
+		l, closer, err := newWithClient(cfg, projectID, logID, opts)
+		if err == nil || t == TransportAPI {
+			return l, closer, err
+		}
+		apiErr = err
+	}
+
+	o = newOptions(append([]Option{WithProjectID(projectID)}, opts...))
+	w := o.structuredOutput
+	if w == nil {
+		w = os.Stdout
+	}
+	o.logger = NewJSONLogger(w)
+	o.standalone = true
+	l, c, closeErrSink, err := newWithOptions(cfg, nil, logID, o)
+	if err != nil {
+		return nil, nil, err
+	}
+	if apiErr != nil {
+		l.Warn("Cloud Logging API unavailable, writing structured logs to standard output", zap.Error(apiErr))
+	}
+	return l, &Closer{core: c, closeErrSink: closeErrSink}, nil
+}
+
+// JSONLogger is a GoogleCloudLogger which writes entries as the structured
+// JSON the Cloud Logging agents, and Cloud Run and Cloud Functions, turn into
+// LogEntries: one object per line, with the payload fields at the top level
+// alongside "severity", "timestamp", "httpRequest" and the
+// "logging.googleapis.com/*" fields. Payload fields whose keys would be
+// mistaken for those are moved aside, with a MovedKeyPrefix. The resource and
+// log name of entries are left to the agent.
+//
+// It's safe for concurrent use.
+type JSONLogger struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewJSONLogger returns a JSONLogger writing to w.
+func NewJSONLogger(w io.Writer) *JSONLogger {
+	return &JSONLogger{w: w}
+}
+
+// Log implements GoogleCloudLogger. Errors are returned by the next Flush.
+func (l *JSONLogger) Log(e gcl.Entry) {
+	b, err := structuredJSON(e)
+	if err != nil {
+		// Rather than lose the entry entirely, write what's left of it
+		// without the payload.
+		b, _ = structuredJSON(gcl.Entry{
+			Timestamp: e.Timestamp,
+			Severity:  e.Severity,
+			Payload:   map[string]interface{}{"message": "unencodable entry: " + err.Error()},
+			Labels:    e.Labels,
+			Trace:     e.Trace,
+			SpanID:    e.SpanID,
+		})
+	}
+	b = append(b, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, werr := l.w.Write(b); werr != nil {
+		err = werr
+	}
+	if l.err == nil {
+		l.err = err
+	}
+}
+
+// Flush implements GoogleCloudLogger. It syncs the writer if it can be, and
+// returns the first error since the last Flush.
+func (l *JSONLogger) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	err := l.err
+	l.err = nil
+	if s, ok := l.w.(interface{ Sync() error }); ok && err == nil {
+		err = s.Sync()
+		if isUnsyncable(err) {
+			err = nil
+		}
+	}
+	return err
+}
+
+// isUnsyncable reports whether err only says that the output, such as a
+// terminal or a pipe, can't be synced.
+func isUnsyncable(err error) bool {
+	return errors.Is(err, syscall.EINVAL) || errors.Is(err, syscall.ENOTTY)
+}
+
+// structuredJSON encodes e in the structured JSON format.
+func structuredJSON(e gcl.Entry) ([]byte, error) {
+	m := make(map[string]interface{})
+	switch p := e.Payload.(type) {
+	case map[string]interface{}:
+		for k, v := range p {
+			if isEnvelopeKey(k) {
+				k = MovedKeyPrefix + k
+			}
+			m[k] = v
+		}
+	case nil:
+	default:
+		m["message"] = p
+	}
+
+	m[severityKey] = logtypepb.LogSeverity(e.Severity).String()
+	if !e.Timestamp.IsZero() {
+		m[timestampKey] = e.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	if len(e.Labels) > 0 {
+		m[LabelsKey] = e.Labels
+	}
+	if e.InsertID != "" {
+		m[InsertIDKey] = e.InsertID
+	}
+	if e.Trace != "" {
+		m[TraceKey] = e.Trace
+	}
+	if e.SpanID != "" {
+		m[SpanIDKey] = e.SpanID
+	}
+	if e.TraceSampled {
+		m[TraceSampledKey] = true
+	}
+	if e.Operation != nil {
+		m[OperationKey] = protoJSON{e.Operation}
+	}
+	if e.SourceLocation != nil {
+		m[sourceLocationKey] = protoJSON{e.SourceLocation}
+	}
+	if r := httpRequestProto(e.HTTPRequest); r != nil {
+		m[HTTPRequestKey] = protoJSON{r}
+	}
+	return json.Marshal(m)
+}
+
+// protoJSON encodes a message the way the LogEntry JSON format does.
+type protoJSON struct {
+	m proto.Message
+}
+
+func (p protoJSON) MarshalJSON() ([]byte, error) {
+	return protojson.Marshal(p.m)
+}
+
+// httpRequestProto converts r the way the GCL client does.
+func httpRequestProto(r *gcl.HTTPRequest) *logtypepb.HttpRequest {
+	if r == nil || r.Request == nil {
+		return nil
+	}
+	u := *r.Request.URL
+	u.Fragment = ""
+	pb := &logtypepb.HttpRequest{
+		RequestMethod:                  r.Request.Method,
+		RequestUrl:                     u.String(),
+		RequestSize:                    r.RequestSize,
+		Status:                         int32(r.Status),
+		ResponseSize:                   r.ResponseSize,
+		UserAgent:                      r.Request.UserAgent(),
+		ServerIp:                       r.LocalIP,
+		RemoteIp:                       r.RemoteIP,
+		Referer:                        r.Request.Referer(),
+		CacheHit:                       r.CacheHit,
+		CacheValidatedWithOriginServer: r.CacheValidatedWithOriginServer,
+		Protocol:                       r.Request.Proto,
+		CacheFillBytes:                 r.CacheFillBytes,
+		CacheLookup:                    r.CacheLookup,
+	}
+	if r.Latency != 0 {
+		pb.Latency = durationpb.New(r.Latency)
+	}
+	return pb
+}
@@ -0,0 +1,139 @@
+package zapgcl
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestJSONLogger(t *testing.T) {
+	var buf bytes.Buffer
+	c := &Core{Logger: NewJSONLogger(&buf), ProjectID: "proj", SeverityMapping: DefaultSeverityMapping}
+
+	req := httptest.NewRequest("GET", "http://example.com/path?q=1", nil)
+	fields := []zapcore.Field{
+		zap.String("key", "value"),
+		zap.String(TraceKey, "abc"),
+		zap.String(SpanIDKey, "def"),
+		zap.String(LabelPrefix+"env", "test"),
+		HTTP(req, 200, 12, 1500*time.Millisecond),
+	}
+	e := zapcore.Entry{
+		Level:   zapcore.WarnLevel,
+		Time:    time.Date(2020, 1, 2, 3, 4, 5, 6, time.UTC),
+		Message: "hello",
+		Caller:  zapcore.EntryCaller{Defined: true, File: "main.go", Line: 7, Function: "main.main"},
+	}
+	if err := c.Write(e, fields); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("%v: %s", err, buf.Bytes())
+	}
+	expected := map[string]interface{}{
+		"message":                       "hello",
+		"key":                           "value",
+		"severity":                      "WARNING",
+		"timestamp":                     "2020-01-02T03:04:05.000000006Z",
+		"logging.googleapis.com/trace":  "projects/proj/traces/abc",
+		"logging.googleapis.com/spanId": "def",
+		"logging.googleapis.com/labels": map[string]interface{}{"env": "test"},
+		"logging.googleapis.com/sourceLocation": map[string]interface{}{
+			"file": "main.go", "line": "7", "function": "main.main",
+		},
+		"httpRequest": map[string]interface{}{
+			"requestMethod": "GET",
+			"requestUrl":    "http://example.com/path?q=1",
+			"status":        float64(200),
+			"responseSize":  "12",
+			"remoteIp":      "192.0.2.1:1234",
+			"protocol":      "HTTP/1.1",
+			"latency":       "1.500s",
+		},
+	}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestJSONLoggerKeyCollisions(t *testing.T) {
+	var buf bytes.Buffer
+	c := &Core{Logger: NewJSONLogger(&buf), SeverityMapping: DefaultSeverityMapping}
+	fields := []zapcore.Field{
+		zap.String("severity", "mine"),
+		zap.String("timestamp", "then"),
+		zap.String("logging.googleapis.com/other", "x"),
+	}
+	if err := c.Write(zapcore.Entry{Level: zapcore.ErrorLevel, Message: "hello"}, fields); err != nil {
+		t.Fatal(err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("%v: %s", err, buf.Bytes())
+	}
+	expected := map[string]interface{}{
+		"message":                    "hello",
+		"severity":                   "ERROR",
+		MovedKeyPrefix + "severity":  "mine",
+		MovedKeyPrefix + "timestamp": "then",
+		MovedKeyPrefix + "logging.googleapis.com/other": "x",
+	}
+	if diff := cmp.Diff(expected, got); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestNewAutoStdout(t *testing.T) {
+	for _, k := range append([]string{"K_SERVICE", "FUNCTION_TARGET"}, projectIDEnvVars...) {
+		t.Setenv(k, "")
+	}
+
+	var buf bytes.Buffer
+	cfg := zap.NewProductionConfig()
+	cfg.OutputPaths = nil
+
+	// Without a project, the client can't be created.
+	l, closer, err := NewAuto(cfg, "", "log", WithStructuredOutput(&buf), testResource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Info("hello")
+	if err := closer.Close(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected a warning and an entry, got %q", buf.String())
+	}
+	var warning, entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &warning); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if warning["severity"] != "WARNING" || !strings.Contains(warning["error"].(string), "projectID") {
+		t.Errorf("unexpected warning %v", warning)
+	}
+	if entry["severity"] != "INFO" || entry["message"] != "hello" {
+		t.Errorf("unexpected entry %v", entry)
+	}
+
+	if _, _, err := NewAuto(cfg, "", "log", WithTransport(TransportAPI), testResource); err == nil {
+		t.Error("TransportAPI must not fall back")
+	}
+}
@@ -0,0 +1,79 @@
+package zapgcl
+
+import (
+	"context"
+	"time"
+
+	gcl "cloud.google.com/go/logging"
+	"go.uber.org/zap/zapcore"
+)
+
+// DefaultSyncTimeout is how long entries written synchronously, and the
+// flushes done by FlushHook, are waited for by default.
+const DefaultSyncTimeout = 5 * time.Second
+
+// syncLogger is implemented by GoogleCloudLoggers, such as *gcl.Logger, which
+// can write an entry synchronously.
+type syncLogger interface {
+	LogSync(ctx context.Context, e gcl.Entry) error
+}
+
+// WithSyncDelivery makes the Core send entries enabled by enab synchronously,
+// waiting up to timeout (DefaultSyncTimeout if zero) for each. The default is
+// DPanicLevel and above; pass zapcore.InvalidLevel to buffer every entry.
+func WithSyncDelivery(enab zapcore.LevelEnabler, timeout time.Duration) Option {
+	return optionFunc(func(o *options) {
+		o.syncLevel = enab
+		o.syncTimeout = timeout
+	})
+}
+
+// syncTimeout returns how long synchronous writes and flushes may take.
+func (c *Core) syncTimeout() time.Duration {
+	if c.SyncTimeout <= 0 {
+		return DefaultSyncTimeout
+	}
+	return c.SyncTimeout
+}
+
+// log writes e with logger, synchronously if SyncLevel says so and logger
+// supports it.
+func (c *Core) log(logger GoogleCloudLogger, level zapcore.Level, e gcl.Entry) error {
+	if c.SyncLevel != nil && c.SyncLevel.Enabled(level) {
+		if sl, ok := logger.(syncLogger); ok {
+			ctx, cancel := context.WithTimeout(context.Background(), c.syncTimeout())
+			defer cancel()
+			if err := sl.LogSync(ctx, e); err != nil {
+				return newError("writing entry synchronously: %v", err)
+			}
+			return nil
+		}
+	}
+	logger.Log(e)
+	return nil
+}
+
+// FlushHook returns a zapcore.CheckWriteHook which flushes the Core's loggers,
+// waiting up to SyncTimeout, before handing over to next. Used with
+// zap.WithFatalHook and zap.WithPanicHook, it gives buffered entries a chance
+// to be delivered before the process exits or panics. NewWithOptions, and so
+// New, NewDevelopment and NewProduction, install it for both.
+func (c *Core) FlushHook(next zapcore.CheckWriteHook) zapcore.CheckWriteHook {
+	return flushHook{core: c, next: next}
+}
+
+type flushHook struct {
+	core *Core
+	next zapcore.CheckWriteHook
+}
+
+// OnWrite implements zapcore.CheckWriteHook.
+func (h flushHook) OnWrite(ce *zapcore.CheckedEntry, fields []zapcore.Field) {
+	ctx, cancel := context.WithTimeout(context.Background(), h.core.syncTimeout())
+	h.core.SyncContext(ctx)
+	cancel()
+
+	if h.next != nil {
+		h.next.OnWrite(ce, fields)
+	}
+}
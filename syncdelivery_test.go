@@ -0,0 +1,87 @@
+package zapgcl
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	gcl "cloud.google.com/go/logging"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// syncTestLogger is a testLogger which can also write synchronously.
+type syncTestLogger struct {
+	testLogger
+	synced  []gcl.Entry
+	syncErr error
+}
+
+func (t *syncTestLogger) LogSync(ctx context.Context, e gcl.Entry) error {
+	if _, ok := ctx.Deadline(); !ok {
+		return errors.New("no deadline")
+	}
+	t.mu.Lock()
+	t.synced = append(t.synced, e)
+	t.mu.Unlock()
+	return t.syncErr
+}
+
+func TestCoreSyncDelivery(t *testing.T) {
+	l := &syncTestLogger{}
+	c, err := NewCore(nil, "", WithGoogleCloudLogger(l), WithLevel(zapcore.DebugLevel),
+		WithSyncDelivery(zapcore.ErrorLevel, time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: "buffered"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.With(nil).Write(zapcore.Entry{Level: zapcore.ErrorLevel, Message: "sent"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.entries) != 1 || len(l.synced) != 1 {
+		t.Fatalf("expected one buffered and one synchronous entry, got %d and %d", len(l.entries), len(l.synced))
+	}
+	if msg := l.synced[0].Payload.(map[string]interface{})["message"]; msg != "sent" {
+		t.Errorf("unexpected synchronous entry %v", msg)
+	}
+
+	l.syncErr = errors.New("unavailable")
+	if err := c.Write(zapcore.Entry{Level: zapcore.FatalLevel}, nil); err == nil {
+		t.Error("synchronous write errors should be returned")
+	}
+
+	// Loggers which can't write synchronously buffer everything.
+	tl := &testLogger{}
+	c = &Core{Logger: tl, SyncLevel: zapcore.DebugLevel}
+	if err := c.Write(zapcore.Entry{Level: zapcore.FatalLevel}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(tl.entries) != 1 {
+		t.Error("entry should have been buffered")
+	}
+}
+
+func TestCoreFlushHook(t *testing.T) {
+	l := &testLogger{}
+	c := &Core{Logger: l}
+	logger := zap.New(c, zap.WithFatalHook(c.FlushHook(zapcore.WriteThenGoexit)))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		logger.Fatal("bye")
+		t.Error("Fatal should not return")
+	}()
+	<-done
+
+	if len(l.entries) != 1 {
+		t.Errorf("expected the entry to be written, got %d", len(l.entries))
+	}
+	if !l.flushed {
+		t.Error("logger should have been flushed")
+	}
+}
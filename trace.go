@@ -0,0 +1,76 @@
+package zapgcl
+
+import (
+	"os"
+	"strings"
+
+	gcl "cloud.google.com/go/logging"
+)
+
+const (
+	// TraceKey is the payload field key to use to set the trace field in the
+	// LogEntry object. The value may be a bare trace ID or a full
+	// "projects/<project>/traces/<id>" resource name.
+	TraceKey = "logging.googleapis.com/trace"
+
+	// SpanIDKey is the payload field key to use to set the spanId field in
+	// the LogEntry object.
+	SpanIDKey = "logging.googleapis.com/spanId"
+
+	// TraceSampledKey is the payload field key to use to set the
+	// traceSampled field in the LogEntry object.
+	TraceSampledKey = "logging.googleapis.com/trace_sampled"
+)
+
+// projectIDEnvVars are consulted, in order, when a Core has no ProjectID.
+var projectIDEnvVars = []string{"GOOGLE_CLOUD_PROJECT", "GCP_PROJECT", "GCLOUD_PROJECT"}
+
+// projectIDFromEnv returns the project ID advertised by the environment, or
+// the empty string.
+func projectIDFromEnv() string {
+	for _, k := range projectIDEnvVars {
+		if v := os.Getenv(k); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// traceName expands a bare trace ID into the resource name Cloud Logging
+// expects. Values which are already resource names are returned unchanged,
+// except for the "projects//traces/<id>" form produced by helpers which ran
+// without a project ID, which is repaired.
+func traceName(projectID, trace string) string {
+	if strings.HasPrefix(trace, "projects//traces/") {
+		trace = strings.TrimPrefix(trace, "projects//traces/")
+	}
+	if trace == "" || strings.Contains(trace, "/traces/") {
+		return trace
+	}
+	if projectID == "" {
+		projectID = projectIDFromEnv()
+	}
+	if projectID == "" {
+		return trace
+	}
+	return "projects/" + projectID + "/traces/" + trace
+}
+
+// extractTrace moves the Cloud Trace special fields from the payload into
+// the entry.
+func (c *Core) extractTrace(entry *gcl.Entry, payload map[string]interface{}) {
+	if v, ok := payload[TraceKey].(string); ok {
+		entry.Trace = traceName(c.ProjectID, v)
+	}
+	delete(payload, TraceKey)
+
+	if v, ok := payload[SpanIDKey].(string); ok {
+		entry.SpanID = v
+	}
+	delete(payload, SpanIDKey)
+
+	if v, ok := payload[TraceSampledKey].(bool); ok {
+		entry.TraceSampled = v
+	}
+	delete(payload, TraceSampledKey)
+}
@@ -0,0 +1,221 @@
+package zapgcl
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+const (
+	// TraceparentHeader is the W3C Trace Context header.
+	TraceparentHeader = "traceparent"
+
+	// CloudTraceContextHeader is the header Google Cloud load balancers
+	// and Cloud Run set, of the form "TRACE_ID/SPAN_ID;o=OPTIONS", with a
+	// decimal SPAN_ID.
+	CloudTraceContextHeader = "X-Cloud-Trace-Context"
+
+	// GRPCTraceBinHeader is the binary gRPC metadata header of the
+	// OpenCensus trace context format.
+	GRPCTraceBinHeader = "grpc-trace-bin"
+)
+
+// A TraceContext identifies the trace and span a request belongs to.
+type TraceContext struct {
+	// TraceID is 32 lower-case hex digits.
+	TraceID string
+
+	// SpanID is 16 lower-case hex digits, or empty.
+	SpanID string
+
+	Sampled bool
+}
+
+// ParseTraceparent parses a W3C traceparent header, such as
+// "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01".
+func ParseTraceparent(h string) (TraceContext, bool) {
+	parts := strings.Split(strings.TrimSpace(h), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return TraceContext{}, false
+	}
+	traceID, spanID, flags := parts[1], parts[2], parts[3]
+	if !isHexID(traceID, 32) || !isHexID(spanID, 16) || len(flags) != 2 {
+		return TraceContext{}, false
+	}
+	f, err := strconv.ParseUint(flags, 16, 8)
+	if err != nil {
+		return TraceContext{}, false
+	}
+	return TraceContext{TraceID: traceID, SpanID: spanID, Sampled: f&1 == 1}, true
+}
+
+// ParseCloudTraceContext parses an X-Cloud-Trace-Context header, such as
+// "105445aa7843bc8bf206b12000100000/1;o=1". The span ID is converted to hex.
+func ParseCloudTraceContext(h string) (TraceContext, bool) {
+	h = strings.TrimSpace(h)
+	h, opts, _ := strings.Cut(h, ";")
+	traceID, span, _ := strings.Cut(h, "/")
+	traceID = strings.ToLower(traceID)
+	if !isHexID(traceID, 32) {
+		return TraceContext{}, false
+	}
+	tc := TraceContext{TraceID: traceID, Sampled: opts == "o=1"}
+	if span != "" {
+		n, err := strconv.ParseUint(span, 10, 64)
+		if err != nil {
+			return TraceContext{}, false
+		}
+		if n != 0 {
+			tc.SpanID = fmt.Sprintf("%016x", n)
+		}
+	}
+	return tc, true
+}
+
+// ParseGRPCTraceBin parses the binary value of a grpc-trace-bin header: a
+// version byte of 0, then the trace ID, span ID and trace options fields,
+// each preceded by its field ID.
+func ParseGRPCTraceBin(b []byte) (TraceContext, bool) {
+	if len(b) < 1+1+16 || b[0] != 0 || b[1] != 0 {
+		return TraceContext{}, false
+	}
+	tc := TraceContext{TraceID: hex.EncodeToString(b[2:18])}
+	b = b[18:]
+	if len(b) >= 1+8 && b[0] == 1 {
+		tc.SpanID = hex.EncodeToString(b[1:9])
+		b = b[9:]
+	}
+	if len(b) >= 1+1 && b[0] == 2 {
+		tc.Sampled = b[1]&1 == 1
+	}
+	if !isHexID(tc.TraceID, 32) || tc.SpanID != "" && !isHexID(tc.SpanID, 16) {
+		return TraceContext{}, false
+	}
+	return tc, true
+}
+
+// TraceContextFromHeader returns the trace context of a request, taken from
+// its traceparent header, or else from its X-Cloud-Trace-Context header. If
+// neither is valid, a new, unsampled trace is started.
+func TraceContextFromHeader(h http.Header) TraceContext {
+	if tc, ok := ParseTraceparent(h.Get(TraceparentHeader)); ok {
+		return tc
+	}
+	if tc, ok := ParseCloudTraceContext(h.Get(CloudTraceContextHeader)); ok {
+		return tc
+	}
+	return NewTraceContext()
+}
+
+// NewTraceContext starts a new, unsampled trace with a random trace ID and
+// span ID.
+func NewTraceContext() TraceContext {
+	return TraceContext{TraceID: randomHexID(16), SpanID: randomHexID(8)}
+}
+
+// Traceparent returns the W3C traceparent header of the trace context. A
+// random span ID is used if it has none.
+func (tc TraceContext) Traceparent() string {
+	spanID := tc.SpanID
+	if spanID == "" {
+		spanID = randomHexID(8)
+	}
+	flags := "00"
+	if tc.Sampled {
+		flags = "01"
+	}
+	return "00-" + tc.TraceID + "-" + spanID + "-" + flags
+}
+
+// GRPCTraceBin returns the binary value of the grpc-trace-bin header of the
+// trace context. A random span ID is used if it has none.
+func (tc TraceContext) GRPCTraceBin() []byte {
+	spanID := tc.SpanID
+	if spanID == "" {
+		spanID = randomHexID(8)
+	}
+	b := make([]byte, 0, 29)
+	b = append(b, 0, 0)
+	b, _ = hex.AppendDecode(b, []byte(tc.TraceID))
+	b = append(b, 1)
+	b, _ = hex.AppendDecode(b, []byte(spanID))
+	var options byte
+	if tc.Sampled {
+		options = 1
+	}
+	return append(b, 2, options)
+}
+
+// Fields returns the TraceKey, SpanIDKey and TraceSampledKey fields which
+// tie entries to the trace. The trace ID is expanded into a resource name by
+// the Core.
+func (tc TraceContext) Fields() []zap.Field {
+	fields := []zap.Field{zap.String(TraceKey, tc.TraceID)}
+	if tc.SpanID != "" {
+		fields = append(fields, zap.String(SpanIDKey, tc.SpanID))
+	}
+	return append(fields, zap.Bool(TraceSampledKey, tc.Sampled))
+}
+
+// isHexID reports whether s is n lower-case hex digits, not all zero.
+func isHexID(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	zero := true
+	for _, r := range s {
+		switch {
+		case r == '0':
+		case r >= '1' && r <= '9', r >= 'a' && r <= 'f':
+			zero = false
+		default:
+			return false
+		}
+	}
+	return !zero
+}
+
+// randomHexID returns n random bytes as hex.
+func randomHexID(n int) string {
+	b := make([]byte, n)
+	for {
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		for _, c := range b {
+			if c != 0 {
+				return hex.EncodeToString(b)
+			}
+		}
+	}
+}
+
+// traceContextKey is the context.Context key of the TraceContext of a
+// request.
+type traceContextKey struct{}
+
+// withTraceContext returns a copy of ctx carrying tc.
+func withTraceContext(ctx context.Context, tc TraceContext) context.Context {
+	return context.WithValue(ctx, traceContextKey{}, tc)
+}
+
+// TraceContextFromContext returns the trace context of the request being
+// served, as found by the middleware and interceptors of this package, or
+// else that of the OpenTelemetry span context of ctx.
+func TraceContextFromContext(ctx context.Context) (TraceContext, bool) {
+	if tc, ok := ctx.Value(traceContextKey{}).(TraceContext); ok {
+		return tc, true
+	}
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return TraceContext{}, false
+	}
+	return TraceContext{TraceID: sc.TraceID().String(), SpanID: sc.SpanID().String(), Sampled: sc.IsSampled()}, true
+}
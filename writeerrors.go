@@ -0,0 +1,251 @@
+package zapgcl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	gcl "cloud.google.com/go/logging"
+	"cloud.google.com/go/logging/apiv2/loggingpb"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+// writeLogEntriesMethod is the gRPC method entries are written with.
+const writeLogEntriesMethod = "/google.logging.v2.LoggingServiceV2/WriteLogEntries"
+
+// A WriteErrorSummary counts the errors reported for one error code.
+type WriteErrorSummary struct {
+	// Errors is the number of errors reported.
+	Errors int64
+
+	// Entries and Bytes are the number and encoded size of the entries
+	// known to have been dropped. Entries dropped by a failed write are
+	// only known if the client was created with DroppedEntriesClientOption;
+	// the size of entries dropped before being sent is never known.
+	Entries int64
+	Bytes   int64
+}
+
+// WriteErrors collects the errors the GCL client reports while writing
+// entries asynchronously, which otherwise only reach its OnError function.
+// It's safe for concurrent use.
+type WriteErrors struct {
+	// Output, if set, is where errors are written, in the same format zap
+	// uses for its own errors. If it's nil, NewWithOptions writes them to the
+	// ErrorOutputPaths of the zap.Config instead, and NewCore passes them on
+	// to the OnError function the client had before any WriteErrors was
+	// attached.
+	Output zapcore.WriteSyncer
+
+	// Handler, if set, is called with every error. Like gcl.Client.OnError,
+	// it's never called concurrently and is expected to return quickly.
+	Handler func(error)
+
+	mu      sync.Mutex
+	summary map[codes.Code]WriteErrorSummary
+}
+
+// WithWriteErrors makes w handle the errors of the client the Core writes
+// with. Since a client has a single OnError function, only the last Core
+// built for a client with this option gets them.
+func WithWriteErrors(w *WriteErrors) Option {
+	return optionFunc(func(o *options) {
+		o.writeErrors = w
+	})
+}
+
+// writeErrorsHook is the OnError function of a client with WriteErrors. It
+// passes errors to the WriteErrors attached last, which passes them on to
+// output or, failing that, to the OnError function the client had before
+// any was attached.
+type writeErrorsHook struct {
+	w      atomic.Pointer[WriteErrors]
+	output zapcore.WriteSyncer
+	prev   func(error)
+}
+
+func (h *writeErrorsHook) handle(err error) {
+	if p, ok := err.(*writeErrorsProbe); ok {
+		p.hook = h
+		return
+	}
+	h.w.Load().handle(err, h.output, h.prev)
+}
+
+// writeErrorsProbe is passed to the OnError function of a client, once it's
+// known to be a writeErrorsHook, to find which one it is.
+type writeErrorsProbe struct {
+	hook *writeErrorsHook
+}
+
+func (*writeErrorsProbe) Error() string { return "zapgcl: WriteErrors probe" }
+
+// hookPC is the code pointer of the OnError functions attach installs.
+var hookPC = reflect.ValueOf((&writeErrorsHook{}).handle).Pointer()
+
+// attachMu serialises attach.
+var attachMu sync.Mutex
+
+// attach makes w handle the errors of client, replacing any WriteErrors
+// attached before rather than chaining to it. Errors are written to output
+// if w has no Output. The hook lives in client.OnError, so it goes away with
+// the client.
+func (w *WriteErrors) attach(client *gcl.Client, output zapcore.WriteSyncer) {
+	attachMu.Lock()
+	defer attachMu.Unlock()
+	var h *writeErrorsHook
+	if prev := client.OnError; prev != nil && reflect.ValueOf(prev).Pointer() == hookPC {
+		p := &writeErrorsProbe{}
+		prev(p)
+		h = p.hook
+	}
+	if h == nil {
+		h = &writeErrorsHook{prev: client.OnError}
+		client.OnError = h.handle
+	}
+	if output != nil {
+		h.output = output
+	}
+	h.w.Store(w)
+}
+
+// Summary returns the errors reported so far, by error code. Errors which
+// don't carry a gRPC status are counted under codes.Unknown, except for
+// gcl.ErrOverflow (codes.ResourceExhausted) and gcl.ErrOversizedEntry
+// (codes.InvalidArgument).
+func (w *WriteErrors) Summary() map[codes.Code]WriteErrorSummary {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	summary := make(map[codes.Code]WriteErrorSummary, len(w.summary))
+	for code, s := range w.summary {
+		summary[code] = s
+	}
+	return summary
+}
+
+func (w *WriteErrors) handle(err error, output zapcore.WriteSyncer, prev func(error)) {
+	w.count(err)
+
+	if w.Output != nil {
+		output = w.Output
+	}
+	switch {
+	case output != nil:
+		fmt.Fprintf(output, "%v zapgcl write error: %v\n", time.Now().UTC(), err)
+		output.Sync()
+	case prev != nil:
+		prev(err)
+	}
+
+	if w.Handler != nil {
+		w.Handler(err)
+	}
+}
+
+// count adds err to the summary.
+func (w *WriteErrors) count(err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.summary == nil {
+		w.summary = make(map[codes.Code]WriteErrorSummary)
+	}
+	add := func(code codes.Code, errs, entries, bytes int64) {
+		s := w.summary[code]
+		s.Errors += errs
+		s.Entries += entries
+		s.Bytes += bytes
+		w.summary[code] = s
+	}
+
+	switch {
+	case errors.Is(err, gcl.ErrOverflow):
+		add(codes.ResourceExhausted, 1, 1, 0)
+		return
+	case errors.Is(err, gcl.ErrOversizedEntry):
+		add(codes.InvalidArgument, 1, 1, 0)
+		return
+	}
+
+	code := status.Code(err)
+	var we *writeError
+	if !errors.As(err, &we) {
+		add(code, 1, 0, 0)
+		return
+	}
+
+	// With partial success, only the entries listed in the details were
+	// dropped, each for its own reason.
+	if st, ok := status.FromError(we.err); ok {
+		for _, d := range st.Details() {
+			pe, ok := d.(*loggingpb.WriteLogEntriesPartialErrors)
+			if !ok {
+				continue
+			}
+			add(code, 1, 0, 0)
+			for i, est := range pe.GetLogEntryErrors() {
+				var size int64
+				if int(i) < len(we.sizes) {
+					size = int64(we.sizes[i])
+				}
+				add(codes.Code(est.GetCode()), 0, 1, size)
+			}
+			return
+		}
+	}
+
+	var bytes int64
+	for _, size := range we.sizes {
+		bytes += int64(size)
+	}
+	add(code, 1, int64(len(we.sizes)), bytes)
+}
+
+// writeError is a failed WriteLogEntries call, along with the sizes of the
+// entries it was writing.
+type writeError struct {
+	err   error
+	sizes []int
+}
+
+func (e *writeError) Error() string { return e.err.Error() }
+func (e *writeError) Unwrap() error { return e.err }
+
+// GRPCStatus lets the GCL client's retry logic see the status of err.
+func (e *writeError) GRPCStatus() *status.Status {
+	st, _ := status.FromError(e.err)
+	return st
+}
+
+// DroppedEntriesClientOption returns an option for gcl.NewClient which lets
+// WriteErrors count the entries, and their size, dropped by failed writes.
+// NewDevelopment and NewProduction use it.
+func DroppedEntriesClientOption() option.ClientOption {
+	return option.WithGRPCDialOption(grpc.WithChainUnaryInterceptor(countDroppedEntries))
+}
+
+// countDroppedEntries is a grpc.UnaryClientInterceptor which attaches the
+// entries' sizes to the errors of WriteLogEntries calls.
+func countDroppedEntries(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err == nil || method != writeLogEntriesMethod {
+		return err
+	}
+	wr, ok := req.(*loggingpb.WriteLogEntriesRequest)
+	if !ok {
+		return err
+	}
+	sizes := make([]int, len(wr.GetEntries()))
+	for i, e := range wr.GetEntries() {
+		sizes[i] = proto.Size(e)
+	}
+	return &writeError{err: err, sizes: sizes}
+}
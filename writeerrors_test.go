@@ -0,0 +1,164 @@
+package zapgcl
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	gcl "cloud.google.com/go/logging"
+	"cloud.google.com/go/logging/apiv2/loggingpb"
+	"github.com/google/go-cmp/cmp"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestCountDroppedEntries(t *testing.T) {
+	req := &loggingpb.WriteLogEntriesRequest{Entries: []*loggingpb.LogEntry{
+		{Payload: &loggingpb.LogEntry_TextPayload{TextPayload: "one"}},
+		{Payload: &loggingpb.LogEntry_TextPayload{TextPayload: "two two"}},
+	}}
+	unavailable := grpcstatus.Error(codes.Unavailable, "down")
+	invoker := func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+		return unavailable
+	}
+
+	err := countDroppedEntries(context.Background(), writeLogEntriesMethod, req, nil, nil, invoker)
+	if grpcstatus.Code(err) != codes.Unavailable {
+		t.Errorf("the status must be preserved, got %v", err)
+	}
+	var we *writeError
+	if !errors.As(err, &we) {
+		t.Fatalf("expected a writeError, got %T", err)
+	}
+	expected := []int{proto.Size(req.Entries[0]), proto.Size(req.Entries[1])}
+	if diff := cmp.Diff(expected, we.sizes); diff != "" {
+		t.Error(diff)
+	}
+
+	err = countDroppedEntries(context.Background(), "/other", req, nil, nil, invoker)
+	if err != unavailable {
+		t.Errorf("other methods must not be wrapped, got %v", err)
+	}
+}
+
+func TestWriteErrorsSummary(t *testing.T) {
+	w := &WriteErrors{}
+	w.count(gcl.ErrOverflow)
+	w.count(gcl.ErrOversizedEntry)
+	w.count(errors.New("bad"))
+	w.count(&writeError{err: grpcstatus.Error(codes.Unavailable, "down"), sizes: []int{10, 20}})
+
+	st, err := grpcstatus.New(codes.InvalidArgument, "partial").WithDetails(&loggingpb.WriteLogEntriesPartialErrors{
+		LogEntryErrors: map[int32]*status.Status{
+			0: {Code: int32(codes.PermissionDenied)},
+			2: {Code: int32(codes.InvalidArgument)},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.count(&writeError{err: st.Err(), sizes: []int{1, 2, 3}})
+
+	expected := map[codes.Code]WriteErrorSummary{
+		codes.ResourceExhausted: {Errors: 1, Entries: 1},
+		codes.InvalidArgument:   {Errors: 2, Entries: 2, Bytes: 3},
+		codes.Unknown:           {Errors: 1},
+		codes.Unavailable:       {Errors: 1, Entries: 2, Bytes: 30},
+		codes.PermissionDenied:  {Entries: 1, Bytes: 1},
+	}
+	if diff := cmp.Diff(expected, w.Summary()); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestWriteErrorsOutput(t *testing.T) {
+	client := newTestClient(t)
+	var prev []error
+	client.OnError = func(err error) { prev = append(prev, err) }
+
+	var handled []error
+	w := &WriteErrors{Handler: func(err error) { handled = append(handled, err) }}
+	if _, err := NewCore(client, "log", testResource, WithWriteErrors(w)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without an Output, errors still reach the previous OnError.
+	client.OnError(gcl.ErrOverflow)
+	if len(prev) != 1 || len(handled) != 1 {
+		t.Fatalf("expected the error to be passed on, got %v and %v", prev, handled)
+	}
+
+	out := &strings.Builder{}
+	w.Output = zapcore.AddSync(out)
+	client.OnError(gcl.ErrOverflow)
+	if len(prev) != 1 {
+		t.Error("the previous OnError must not be called when there's an Output")
+	}
+	if !strings.Contains(out.String(), "zapgcl write error: "+gcl.ErrOverflow.Error()) {
+		t.Errorf("unexpected output %q", out.String())
+	}
+	if s := w.Summary()[codes.ResourceExhausted]; s.Errors != 2 {
+		t.Errorf("expected two errors, got %+v", s)
+	}
+}
+
+func TestNewWithOptionsErrorOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors.log")
+	cfg := zap.NewProductionConfig()
+	cfg.OutputPaths = nil
+	cfg.ErrorOutputPaths = []string{path}
+
+	client := newTestClient(t)
+	w := &WriteErrors{}
+	if _, err := NewWithOptions(cfg, client, "log", testResource, WithWriteErrors(w)); err != nil {
+		t.Fatal(err)
+	}
+	client.OnError(errors.New("lost"))
+	if w.Output != nil {
+		t.Error("the caller's WriteErrors must not be modified")
+	}
+	if w.Summary()[codes.Unknown].Errors != 1 {
+		t.Errorf("expected the error to be counted, got %v", w.Summary())
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "zapgcl write error: lost") {
+		t.Errorf("unexpected error output %q", b)
+	}
+}
+
+func TestWriteErrorsAttach(t *testing.T) {
+	client := newTestClient(t)
+	var calls []string
+	client.OnError = func(error) { calls = append(calls, "orig") }
+
+	w1 := &WriteErrors{Handler: func(error) { calls = append(calls, "w1") }}
+	w2 := &WriteErrors{Handler: func(error) { calls = append(calls, "w2") }}
+	for _, w := range []*WriteErrors{w1, w1, w2, w2} {
+		if _, err := NewCore(client, "log", testResource, WithWriteErrors(w)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	client.OnError(gcl.ErrOverflow)
+
+	if diff := cmp.Diff([]string{"orig", "w2"}, calls); diff != "" {
+		t.Error(diff)
+	}
+	if s := w2.Summary()[codes.ResourceExhausted]; s.Errors != 1 {
+		t.Errorf("expected the error to be counted once, got %+v", s)
+	}
+	if len(w1.Summary()) != 0 {
+		t.Errorf("expected the replaced WriteErrors not to get errors, got %v", w1.Summary())
+	}
+}
@@ -26,19 +26,15 @@ package zapgcl
 import (
 	"context"
 	"fmt"
-	"net/http"
-	"net/url"
-	"runtime"
 	"sort"
-	"strconv"
-	"strings"
 	"time"
 
 	gcl "cloud.google.com/go/logging"
 	"cloud.google.com/go/logging/apiv2/loggingpb"
-	"github.com/blendle/zapdriver"
+	"go.uber.org/multierr"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
 )
 
 const (
@@ -52,58 +48,111 @@ func newClient(projectID string) (*gcl.Client, error) {
 		return nil, newError("the provided projectID is empty")
 	}
 
-	return gcl.NewClient(context.Background(), projectID)
+	return gcl.NewClient(context.Background(), projectID, DroppedEntriesClientOption(), SplitEntriesClientOption())
 }
 
 // NewDevelopment builds a development Logger that writes DebugLevel and above
 // logs to standard error in a human-friendly format, as well as to
 // Stackdriver using Application Default Credentials.
-func NewDevelopment(projectID string, logID string) (*zap.Logger, error) {
-	if logID == "" {
-		return nil, fmt.Errorf("the provided logID is empty")
-	}
-
-	client, err := newClient(projectID)
-	if err != nil {
-		return nil, newError("creating Google Logging client: %v", err)
-	}
+//
+// The GCL client it creates can't be closed; use NewDevelopmentWithCloser
+// for that.
+func NewDevelopment(projectID string, logID string, opts ...Option) (*zap.Logger, error) {
+	l, _, err := NewDevelopmentWithCloser(projectID, logID, opts...)
+	return l, err
+}
 
-	return New(zap.NewDevelopmentConfig(), client, logID)
+// NewDevelopmentWithCloser is like NewDevelopment, but also returns a Closer
+// for the GCL client it creates.
+func NewDevelopmentWithCloser(projectID string, logID string, opts ...Option) (*zap.Logger, *Closer, error) {
+	return newWithClient(zap.NewDevelopmentConfig(), projectID, logID, opts)
 }
 
 // NewProduction builds a production Logger that writes InfoLevel and above
 // logs to standard error as JSON, as well as to Stackdriver using Application
 // Default Credentials.
-func NewProduction(projectID string, logID string) (*zap.Logger, error) {
+//
+// The GCL client it creates can't be closed; use NewProductionWithCloser for
+// that.
+func NewProduction(projectID string, logID string, opts ...Option) (*zap.Logger, error) {
+	l, _, err := NewProductionWithCloser(projectID, logID, opts...)
+	return l, err
+}
+
+// NewProductionWithCloser is like NewProduction, but also returns a Closer
+// for the GCL client it creates.
+func NewProductionWithCloser(projectID string, logID string, opts ...Option) (*zap.Logger, *Closer, error) {
+	return newWithClient(zap.NewProductionConfig(), projectID, logID, opts)
+}
+
+// newWithClient builds a Logger writing to a client of its own.
+func newWithClient(cfg zap.Config, projectID string, logID string, opts []Option) (*zap.Logger, *Closer, error) {
 	if logID == "" {
-		return nil, fmt.Errorf("the provided logID is empty")
+		return nil, nil, fmt.Errorf("the provided logID is empty")
 	}
 
 	client, err := newClient(projectID)
 	if err != nil {
-		return nil, newError("creating Google Logging client: %v", err)
+		return nil, nil, newError("creating Google Logging client: %v", err)
 	}
 
-	return New(zap.NewProductionConfig(), client, logID)
+	opts = append([]Option{WithProjectID(projectID)}, opts...)
+	o := newOptions(opts)
+	l, c, closeErrSink, err := newWithOptions(cfg, client, logID, o)
+	if err != nil {
+		client.Close()
+		return nil, nil, err
+	}
+	return l, &Closer{core: c, client: client, errors: o.writeErrors, errorOutput: o.errorOutput, closeErrSink: closeErrSink}, nil
 }
 
 // New creates a new zap.Logger which will write entries to Stackdriver in
 // addition to the destination specified by the provided zap configuration.
 func New(cfg zap.Config, client *gcl.Client, logID string, opts ...zap.Option) (*zap.Logger, error) {
-	zl, err := cfg.Build()
-	if err != nil {
-		return nil, err
+	return NewWithOptions(cfg, client, logID, WithZapOptions(opts...))
+}
+
+// NewWithOptions is like New, but also takes Options for the Stackdriver
+// Core. Options for the zap.Logger itself are passed with WithZapOptions.
+//
+// Errors writing to Stackdriver, which happen asynchronously, are written to
+// the ErrorOutputPaths of cfg, unless WithWriteErrors says otherwise. Fatal
+// and Panic entries flush Stackdriver before exiting or panicking (see
+// Core.FlushHook).
+//
+// Like those of cfg.Build, the outputs opened are never closed; a Closer
+// closes the error outputs of the loggers it comes with.
+func NewWithOptions(cfg zap.Config, client *gcl.Client, logID string, opts ...Option) (*zap.Logger, error) {
+	l, _, _, err := newWithOptions(cfg, client, logID, newOptions(opts))
+	return l, err
+}
+
+// newWithOptions builds the Logger of cfg, teed to a Core. It also returns
+// the function closing the ErrorOutputPaths of cfg, which it opens once.
+func newWithOptions(cfg zap.Config, client *gcl.Client, logID string, o *options) (*zap.Logger, *Core, func(), error) {
+	if client == nil && o.logger == nil {
+		return nil, nil, nil, fmt.Errorf("The provided GCL client is nil")
 	}
 
-	if client == nil {
-		return nil, fmt.Errorf("The provided GCL client is nil")
+	// Only the core of the built logger is used, so it doesn't need error
+	// outputs of its own.
+	buildCfg := cfg
+	buildCfg.ErrorOutputPaths = nil
+	zl, err := buildCfg.Build()
+	if err != nil {
+		return nil, nil, nil, err
 	}
 
 	// Here we translate all the members of a zap.Config into a zap.Option
 	// array to pass to zap.New(), since otherwise the config passed in to
 	// zl by cfg.Build() is lost when we grab its core; we basically copy
 	// zap.Config.buildOptions().
+	errSink, closeErrSink, err := zap.Open(cfg.ErrorOutputPaths...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 	var nopts []zap.Option
+	nopts = append(nopts, zap.ErrorOutput(errSink))
 	if cfg.Development {
 		nopts = append(nopts, zap.Development())
 	}
@@ -141,17 +190,43 @@ func New(cfg zap.Config, client *gcl.Client, logID string, opts ...zap.Option) (
 	}
 
 	// The user-supplied options must override our defaults
-	opts = append(nopts, opts...)
+	nopts = append(nopts, o.zapOpts...)
 
-	tee := Tee(zl.Core(), client, logID)
-	return zap.New(tee, opts...), nil
+	// Write errors go to the error outputs, unless the WriteErrors, which
+	// belongs to the caller and so isn't modified, has an Output.
+	if client != nil {
+		if o.writeErrors == nil {
+			o.writeErrors = &WriteErrors{}
+		}
+		o.errorOutput = errSink
+	}
+
+	var tee zapcore.Core
+	var c *Core
+	if o.standalone {
+		if o.level == nil {
+			o.level = cfg.Level
+		}
+		c = newCore(client, logID, o)
+		tee = c
+	} else {
+		tee, c = newTee(zl.Core(), client, logID, o)
+	}
+
+	// The flush hooks go first so that user-supplied hooks replace them.
+	hooks := []zap.Option{
+		zap.WithFatalHook(c.FlushHook(zapcore.WriteThenFatal)),
+		zap.WithPanicHook(c.FlushHook(zapcore.WriteThenPanic)),
+	}
+	return zap.New(tee, append(hooks, nopts...)...), c, closeErrSink, nil
 }
 
 // A Core implements zapcore.Core and writes entries to a Logger from the
 // Google Cloud package.
 //
 // It's safe for concurrent use by multiple goroutines as long as it's not
-// mutated after first use.
+// mutated after first use. NewCore builds one from Options, which avoids
+// having to set its fields by hand.
 type Core struct {
 	// Logger is a logging.Logger instance from the Google Cloud Platform Go
 	// library.
@@ -164,48 +239,195 @@ type Core struct {
 	// This must not be mutated after the Core's first use.
 	SeverityMapping map[zapcore.Level]gcl.Severity
 
-	// MinLevel is the minimum level for a log entry to be written.
+	// LevelEnabler decides which entries are written. If nil, entries at
+	// MinLevel and above are written.
+	LevelEnabler zapcore.LevelEnabler
+
+	// MinLevel is the minimum level for a log entry to be written when
+	// LevelEnabler is nil.
 	MinLevel zapcore.Level
 
-	// fields should be built once and never mutated again.
-	fields map[string]interface{}
+	// ProjectID is used to expand bare trace IDs into
+	// "projects/<ProjectID>/traces/<id>". If empty, the project is taken
+	// from the GOOGLE_CLOUD_PROJECT environment variable.
+	ProjectID string
+
+	// HTTPRequestExtractors convert HTTPRequestKey fields into the entry's
+	// HTTPRequest field. If nil, DefaultHTTPRequestExtractors is used.
+	//
+	// This must not be mutated after the Core's first use.
+	HTTPRequestExtractors []HTTPRequestExtractor
+
+	// Resource, if set, overrides the MonitoredResource of the Logger for
+	// every entry written by this Core.
+	Resource *mrpb.MonitoredResource
+
+	// ErrorReporting, if set, shapes Error and higher level entries for
+	// Cloud Error Reporting.
+	ErrorReporting *ErrorReporting
+
+	// LoggerNames decides what happens to the LoggerName of entries, and
+	// LogIDForName derives log IDs from logger names under the
+	// LoggerNameLogID policy (DefaultLogIDForName if nil). The
+	// LoggerNameLogID policy only applies to Cores built by Tee, which know
+	// how to create further loggers.
+	LoggerNames  LoggerNamePolicy
+	LogIDForName func(name string) string
+
+	// Oversize decides what happens to entries whose estimated encoded size
+	// is over MaxEntrySize bytes. A MaxEntrySize of zero means
+	// DefaultMaxEntrySize, and a negative one disables the check.
+	Oversize     OversizePolicy
+	MaxEntrySize int
+
+	// SyncLevel, if set, decides which entries are sent synchronously, with
+	// the Logger's LogSync method, rather than buffered. Each is waited for
+	// up to SyncTimeout, or DefaultSyncTimeout if that's zero. Loggers
+	// without a LogSync method, which *gcl.Logger has, always buffer.
+	SyncLevel   zapcore.LevelEnabler
+	SyncTimeout time.Duration
+
+	// fields and labels should be built once and never mutated again.
+	// namespace is the path of the zap.Namespace fields opened so far.
+	fields    map[string]interface{}
+	labels    map[string]interface{}
+	namespace []string
+
+	// loggers holds the loggers of named loggers; it's shared with children.
+	loggers *loggerCache
+
+	// budget, set by WithBudget, is shared with children.
+	budget *budget
+
+	// redactor, set by WithRedaction, is shared with children.
+	redactor *redactor
 }
 
 // Tee returns a zapcore.Core that writes entries to both the provided core
-// and to Stackdriver using the provided client and log ID.
+// and to Stackdriver using the provided client and log ID, configured by opts.
 //
 // For fields to be written to Stackdriver, you must use the With() method on
 // the returned Core rather than just on zc. (This function has no way of
 // knowing about fields that already exist on zc. They will be preserved when
 // writing to zc's existing destination, but not to Stackdriver.)
-func Tee(zc zapcore.Core, client *gcl.Client, gclLogID string) zapcore.Core {
-	gc := &Core{
-		Logger:          client.Logger(gclLogID),
-		SeverityMapping: DefaultSeverityMapping,
+func Tee(zc zapcore.Core, client *gcl.Client, gclLogID string, opts ...Option) zapcore.Core {
+	tee, _ := newTee(zc, client, gclLogID, newOptions(opts))
+	return tee
+}
+
+func newTee(zc zapcore.Core, client *gcl.Client, logID string, o *options) (zapcore.Core, *Core) {
+	if o.level == nil {
+		// Following zc rather than probing it once means a zap.AtomicLevel
+		// it was built with keeps applying to Stackdriver.
+		o.level = zc
+	}
+	c := newCore(client, logID, o)
+	return zapcore.NewTee(zc, c), c
+}
+
+// NewCore returns a Core which writes entries to the log with the given ID,
+// configured by opts. Unless WithLevel is used, it writes entries at
+// InfoLevel and above.
+//
+// client may be nil if WithGoogleCloudLogger is used, but then the
+// LoggerNameLogID policy has no effect.
+func NewCore(client *gcl.Client, logID string, opts ...Option) (*Core, error) {
+	o := newOptions(opts)
+	if o.logger == nil {
+		if client == nil {
+			return nil, newError("the provided GCL client is nil")
+		}
+		if logID == "" {
+			return nil, newError("the provided logID is empty")
+		}
 	}
+	return newCore(client, logID, o), nil
+}
 
-	for l := zapcore.DebugLevel; l <= zapcore.FatalLevel; l++ {
-		if zc.Enabled(l) {
-			gc.MinLevel = l
-			break
+func newCore(client *gcl.Client, logID string, o *options) *Core {
+	c := &Core{
+		Logger:                o.logger,
+		SeverityMapping:       o.severityMapping,
+		LevelEnabler:          o.level,
+		ProjectID:             o.projectID,
+		HTTPRequestExtractors: o.extractors,
+		ErrorReporting:        o.errorReporting,
+		LoggerNames:           o.loggerNamePolicy,
+		LogIDForName:          o.logIDForName,
+		Oversize:              o.oversizePolicy,
+		MaxEntrySize:          o.maxEntrySize,
+		SyncLevel:             o.syncLevel,
+		SyncTimeout:           o.syncTimeout,
+	}
+	if o.budget != nil {
+		c.budget = newBudget(o.budget)
+		c.budget.flush = func() { c.writeSummary(false) }
+	}
+	if o.redaction != nil {
+		c.redactor = newRedactor(o.redaction)
+	}
+	if c.SeverityMapping == nil {
+		c.SeverityMapping = DefaultSeverityMapping
+	}
+
+	res := o.detectResource()
+	if c.Logger == nil {
+		c.Logger = client.Logger(logID, o.loggerOptions(res)...)
+	} else {
+		c.Resource = res
+		for k, v := range o.commonLabels {
+			if c.labels == nil {
+				c.labels = make(map[string]interface{}, len(o.commonLabels))
+			}
+			c.labels[k] = v
 		}
 	}
 
-	return zapcore.NewTee(zc, gc)
+	if client != nil && o.writeErrors != nil {
+		o.writeErrors.attach(client, o.errorOutput)
+	}
+
+	if client != nil {
+		lopts := o.loggerOptions(res)
+		c.loggers = newLoggerCache(func(logID string) GoogleCloudLogger {
+			return client.Logger(logID, lopts...)
+		})
+	}
+	return c
 }
 
 // Enabled implements zapcore.Core.
 func (c *Core) Enabled(l zapcore.Level) bool {
+	if c.LevelEnabler != nil {
+		return c.LevelEnabler.Enabled(l)
+	}
 	return l >= c.MinLevel
 }
 
 // With implements zapcore.Core.
 func (c *Core) With(newFields []zapcore.Field) zapcore.Core {
+	fields, ns := clone(c.fields, c.namespace, newFields)
 	return &Core{
-		Logger:          c.Logger,
-		SeverityMapping: c.SeverityMapping,
-		MinLevel:        c.MinLevel,
-		fields:          clone(c.fields, newFields),
+		Logger:                c.Logger,
+		SeverityMapping:       c.SeverityMapping,
+		LevelEnabler:          c.LevelEnabler,
+		MinLevel:              c.MinLevel,
+		ProjectID:             c.ProjectID,
+		HTTPRequestExtractors: c.HTTPRequestExtractors,
+		Resource:              c.Resource,
+		ErrorReporting:        c.ErrorReporting,
+		LoggerNames:           c.LoggerNames,
+		LogIDForName:          c.LogIDForName,
+		Oversize:              c.Oversize,
+		MaxEntrySize:          c.MaxEntrySize,
+		SyncLevel:             c.SyncLevel,
+		SyncTimeout:           c.SyncTimeout,
+		loggers:               c.loggers,
+		budget:                c.budget,
+		redactor:              c.redactor,
+		fields:                fields,
+		labels:                withLabels(c.labels, fields),
+		namespace:             ns,
 	}
 }
 
@@ -221,16 +443,69 @@ func (c *Core) Check(e zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.Checked
 //
 // Certain fields in the zapcore.Entry are used to populate the Stackdriver
 // entry.  The Message field maps to "message", and the LoggerName and Stack
-// fields map to "logger" and "stack", respectively, if they're present.  The
+// fields map to "logger" and "stack", respectively, if they're present (but
+// see LoggerNames).  The
 // Caller field is mapped to the Stackdriver entry object's SourceLocation
 // field.
+//
+// Some payload fields are also lifted into the Stackdriver entry: an
+// HTTPRequestKey field is mapped to its HTTPRequest field (see
+// HTTPRequestExtractor), the TraceKey, SpanIDKey and TraceSampledKey fields,
+// or else the span context of a Context field, to its Trace, SpanID and
+// TraceSampled fields, and an OperationKey field to
+// its Operation field. Labels are taken from a LabelsKey object and from
+// LabelPrefix fields, both on the entry and from With; labels which can't be
+// written are dropped and reported in the returned error, but the entry is
+// still written.
+//
+// If ErrorReporting is set, entries at ErrorLevel and above also get the
+// "@type", "serviceContext" and "context.reportLocation" fields Cloud Error
+// Reporting looks for, their message is followed by a Go-style traceback and
+// they are labelled with their Fingerprint.
+//
+// The Redaction set with WithRedaction is applied once the entry is mapped,
+// Error Reporting fields included, and before it's truncated or split, so
+// that values are matched whole. Entries which would be too large for Cloud
+// Logging are then truncated or split, according to Oversize. Entries over
+// the Budget set with WithBudget are dropped, split entries as a whole, and
+// listed, redacted, in its summaries. Entries enabled by SyncLevel are sent
+// before Write returns.
 func (c *Core) Write(ze zapcore.Entry, newFields []zapcore.Field) error {
+	c.writeSummary(false)
+	if c.budget != nil && !c.budget.allow(ze) {
+		return nil
+	}
+
+	entry, payload, logger, err := c.entry(ze, newFields)
+	entries := c.fit(entry, payload)
+	if c.budget != nil {
+		// The pieces of a split entry are charged together, so that either
+		// all of them are sent or the entry is suppressed as a whole.
+		size := 0
+		for i := range entries {
+			size += entrySize(&entries[i], entries[i].Payload.(map[string]interface{}))
+		}
+		if !c.budget.spend(ze, size) {
+			return err
+		}
+	}
+	for _, e := range entries {
+		err = multierr.Append(err, c.log(logger, ze.Level, e))
+	}
+
+	return err
+}
+
+// entry maps ze and its fields to a gcl.Entry, whose payload is returned
+// alongside it, and picks the logger it's written to. Invalid labels are
+// dropped from the entry and reported in the returned error.
+func (c *Core) entry(ze zapcore.Entry, newFields []zapcore.Field) (gcl.Entry, map[string]interface{}, GoogleCloudLogger, error) {
 	severity, specified := c.SeverityMapping[ze.Level]
 	if !specified {
 		severity = gcl.Default
 	}
 
-	payload := clone(c.fields, newFields)
+	payload, _ := clone(c.fields, c.namespace, newFields)
 
 	if ze.Stack != "" {
 		payload["stack"] = ze.Stack
@@ -241,44 +516,11 @@ func (c *Core) Write(ze zapcore.Entry, newFields []zapcore.Field) error {
 		Timestamp: ze.Time,
 		Severity:  severity,
 		Payload:   payload,
+		Resource:  c.Resource,
 	}
-	if ze.LoggerName != "" {
-		entry.LogName = ze.LoggerName
-	}
-
-	for k, v := range payload {
-		if strings.HasPrefix(k, "labels.") {
-			if len(entry.Labels) == 0 {
-				entry.Labels = make(map[string]string)
-			}
-			new_k := k[7:]
-			entry.Labels[new_k] = v.(string)
-			delete(payload, k)
-		} else if k == "httpRequest" {
-			_v := v.(*zapdriver.HTTPPayload)
-			req := &http.Request{
-				Method: _v.RequestMethod,
-				Header: make(http.Header),
-			}
-			req.URL, _ = url.Parse(_v.RequestURL)
-			req.Header.Set("User-Agent", _v.UserAgent)
-			req.Header.Set("Referer", _v.Referer)
-			reqSize, _ := strconv.ParseInt(_v.RequestSize, 10, 64)
-			respSize, _ := strconv.ParseInt(_v.ResponseSize, 10, 64)
-			latency, _ := time.ParseDuration(_v.Latency)
-			entry.HTTPRequest = &gcl.HTTPRequest{
-				Request:                        req,
-				RequestSize:                    reqSize,
-				Status:                         _v.Status,
-				ResponseSize:                   respSize,
-				Latency:                        latency,
-				LocalIP:                        _v.ServerIP,
-				RemoteIP:                       _v.RemoteIP,
-				CacheHit:                       _v.CacheHit,
-				CacheValidatedWithOriginServer: _v.CacheValidatedWithOriginServer,
-			}
-			delete(payload, k)
-		}
+	logger, named := c.loggerFor(ze.LoggerName)
+	if ze.LoggerName != "" && !named {
+		payload["logger"] = ze.LoggerName
 	}
 
 	insertID, ok := payload[InsertIDKey].(string)
@@ -287,21 +529,34 @@ func (c *Core) Write(ze zapcore.Entry, newFields []zapcore.Field) error {
 	}
 	delete(payload, InsertIDKey)
 
+	c.extractHTTPRequest(&entry, payload)
+	c.extractSpanContext(&entry, payload)
+	c.extractTrace(&entry, payload)
+	c.extractOperation(&entry, payload)
+	err := c.extractLabels(&entry, payload)
+	c.reportError(&entry, ze, payload)
+	c.redact(&entry, payload)
+
 	if ze.Caller.Defined {
 		entry.SourceLocation = &loggingpb.LogEntrySourceLocation{
 			File:     ze.Caller.File,
 			Line:     int64(ze.Caller.Line),
-			Function: runtime.FuncForPC(ze.Caller.PC).Name(),
+			Function: callerFunction(ze.Caller),
 		}
 	}
-	c.Logger.Log(entry)
-
-	return nil
+	return entry, payload, logger, err
 }
 
-// Sync implements zapcore.Core. It flushes the Core's Logger instance.
+// Sync implements zapcore.Core. It flushes the Core's Logger instance, as
+// well as those of any named loggers, after writing the summary of entries
+// suppressed by the Budget, if any.
 func (c *Core) Sync() error {
-	if err := c.Logger.Flush(); err != nil {
+	c.writeSummary(true)
+	err := c.Logger.Flush()
+	if c.loggers != nil {
+		err = multierr.Append(err, c.loggers.flush())
+	}
+	if err != nil {
 		return newError("flushing Google Cloud logger: %v", err)
 	}
 	return nil
@@ -319,86 +574,11 @@ var DefaultSeverityMapping = map[zapcore.Level]gcl.Severity{
 	zapcore.FatalLevel:  gcl.Critical,
 }
 
-// clone creates a new field map without mutating the original.
-func clone(orig map[string]interface{}, newFields []zapcore.Field) map[string]interface{} {
-	clone := make(map[string]interface{})
-
-	for k, v := range orig {
-		clone[k] = v
-	}
-
-	for _, f := range newFields {
-		switch f.Type {
-		// case zapcore.UnknownType:
-		case zapcore.ArrayMarshalerType:
-			clone[f.Key] = f.Interface
-		case zapcore.ObjectMarshalerType:
-			clone[f.Key] = f.Interface
-		case zapcore.BinaryType:
-			clone[f.Key] = f.Interface
-		case zapcore.BoolType:
-			clone[f.Key] = (f.Integer == 1)
-		case zapcore.ByteStringType:
-			clone[f.Key] = f.String
-		case zapcore.Complex128Type:
-			clone[f.Key] = fmt.Sprint(f.Interface)
-		case zapcore.Complex64Type:
-			clone[f.Key] = fmt.Sprint(f.Interface)
-		case zapcore.DurationType:
-			clone[f.Key] = time.Duration(f.Integer).String()
-		case zapcore.Float64Type:
-			clone[f.Key] = float64(f.Integer)
-		case zapcore.Float32Type:
-			clone[f.Key] = float32(f.Integer)
-		case zapcore.Int64Type:
-			clone[f.Key] = int64(f.Integer)
-		case zapcore.Int32Type:
-			clone[f.Key] = int32(f.Integer)
-		case zapcore.Int16Type:
-			clone[f.Key] = int16(f.Integer)
-		case zapcore.Int8Type:
-			clone[f.Key] = int8(f.Integer)
-		case zapcore.StringType:
-			clone[f.Key] = f.String
-		case zapcore.TimeType:
-			// Handle uber-go/zap#425
-			if f.Interface == nil {
-				clone[f.Key] = time.Unix(0, f.Integer)
-			} else {
-				clone[f.Key] = time.Unix(0, f.Integer).In(f.Interface.(*time.Location))
-			}
-		case zapcore.Uint64Type:
-			clone[f.Key] = uint64(f.Integer)
-		case zapcore.Uint32Type:
-			clone[f.Key] = uint32(f.Integer)
-		case zapcore.Uint16Type:
-			clone[f.Key] = uint16(f.Integer)
-		case zapcore.Uint8Type:
-			clone[f.Key] = uint8(f.Integer)
-		case zapcore.UintptrType:
-			clone[f.Key] = uintptr(f.Integer)
-		case zapcore.ReflectType:
-			clone[f.Key] = f.Interface
-		// case zapcore.NamespaceType:
-		case zapcore.StringerType:
-			clone[f.Key] = f.Interface.(fmt.Stringer).String()
-		case zapcore.ErrorType:
-			clone[f.Key] = f.Interface.(error).Error()
-		case zapcore.SkipType:
-			continue
-		default:
-			clone[f.Key] = f.Interface
-		}
-	}
-
-	return clone
-}
-
 const packageName = "gcloudzap"
 
 // newError calls fmt.Errorf() and prefixes the error with the packageName.
 func newError(format string, args ...interface{}) error {
-	return fmt.Errorf(packageName+": "+format, args)
+	return fmt.Errorf(packageName+": "+format, args...)
 }
 
 // GoogleCloudLogger encapsulates the important methods of gcl.Logger
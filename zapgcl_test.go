@@ -1,14 +1,27 @@
 package zapgcl
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	gcl "cloud.google.com/go/logging"
+	"cloud.google.com/go/logging/apiv2/loggingpb"
+	"github.com/blendle/zapdriver"
 	"github.com/google/go-cmp/cmp"
+	gologger "github.com/govargo/go-logger"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	mrpb "google.golang.org/genproto/googleapis/api/monitoredres"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/testing/protocmp"
 )
 
 func TestCoreFields(t *testing.T) {
@@ -56,6 +69,62 @@ func TestTimeField(t *testing.T) {
 	}
 }
 
+func TestCoreFieldEncoding(t *testing.T) {
+	l := &testLogger{}
+	c := (&Core{Logger: l}).With([]zapcore.Field{
+		zap.Namespace("req"),
+		zap.String("id", "1"),
+	})
+
+	fields := []zapcore.Field{
+		zap.Float64("f64", 1.5),
+		zap.Float32("f32", 0.25),
+		zap.Float64("nan", math.NaN()),
+		zap.Float64("inf", math.Inf(-1)),
+		zap.Complex128("c128", complex(1, -2)),
+		zap.Complex64("c64", complex(1.5, 2)),
+		zap.Uint64("u64", math.MaxUint64),
+		zap.Binary("bin", []byte("hi")),
+		zapdriver.OperationStart("op", "p"),
+		zap.Ints("ints", []int{1, 2}),
+		zap.Any("reflected", struct{ A int }{A: 1}),
+		zap.Namespace("inner"),
+		zap.Bool("ok", true),
+	}
+	if err := c.Write(zapcore.Entry{Message: "hello"}, fields); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"message": "hello",
+		"req": map[string]interface{}{
+			"id":   "1",
+			"f64":  1.5,
+			"f32":  float32(0.25),
+			"nan":  "NaN",
+			"inf":  "-Inf",
+			"c128": "1-2i",
+			"c64":  "1.5+2i",
+			"u64":  uint64(math.MaxUint64),
+			"bin":  "aGk=",
+			OperationKey: map[string]interface{}{
+				"id": "op", "producer": "p", "first": true, "last": false,
+			},
+			"ints":      []interface{}{1, 2},
+			"reflected": map[string]interface{}{"A": json.Number("1")},
+			"inner":     map[string]interface{}{"ok": true},
+		},
+	}
+	if diff := cmp.Diff(expected, l.entries[0].Payload); diff != "" {
+		t.Error(diff)
+	}
+
+	// The context namespace must not have been mutated by the write.
+	if diff := cmp.Diff(map[string]interface{}{"id": "1"}, c.(*Core).fields["req"]); diff != "" {
+		t.Error(diff)
+	}
+}
+
 func TestCoreWrite(t *testing.T) {
 	l := &testLogger{}
 	ts := time.Now()
@@ -79,9 +148,9 @@ func TestCoreWrite(t *testing.T) {
 		{
 			Timestamp: ts,
 			Severity:  gcl.Warning,
-			LogName:   "test",
 			Payload: map[string]interface{}{
 				"message": "hello",
+				"logger":  "test",
 				"foo":     "bar",
 				"baz":     "qux",
 			},
@@ -98,9 +167,9 @@ func TestCoreWrite(t *testing.T) {
 	expected = append(expected, gcl.Entry{
 		Timestamp: ts,
 		Severity:  gcl.Warning,
-		LogName:   "test",
 		Payload: map[string]interface{}{
 			"message": "hello",
+			"logger":  "test",
 			"foo":     "bar",
 			"asdf":    "asdf",
 		},
@@ -110,6 +179,368 @@ func TestCoreWrite(t *testing.T) {
 	}
 }
 
+func TestCoreWriteTrace(t *testing.T) {
+	l := &testLogger{}
+	c := &Core{Logger: l, ProjectID: "proj"}
+
+	fields := []zapcore.Field{
+		zap.String(TraceKey, "abc"),
+		zap.String(SpanIDKey, "def"),
+		zap.Bool(TraceSampledKey, true),
+	}
+	if err := c.Write(zapcore.Entry{Message: "hello"}, fields); err != nil {
+		t.Fatal(err)
+	}
+	// zapdriver builds the resource name itself, with an empty project if
+	// none was given.
+	fields = zapdriver.TraceContext("abc", "def", false, "")
+	if err := c.Write(zapcore.Entry{Message: "hello"}, fields); err != nil {
+		t.Fatal(err)
+	}
+	fields = zapdriver.TraceContext("abc", "def", false, "other")
+	if err := c.Write(zapcore.Entry{Message: "hello"}, fields); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []gcl.Entry{
+		{
+			Trace:        "projects/proj/traces/abc",
+			SpanID:       "def",
+			TraceSampled: true,
+			Payload:      map[string]interface{}{"message": "hello"},
+		},
+		{
+			Trace:   "projects/proj/traces/abc",
+			SpanID:  "def",
+			Payload: map[string]interface{}{"message": "hello"},
+		},
+		{
+			Trace:   "projects/other/traces/abc",
+			SpanID:  "def",
+			Payload: map[string]interface{}{"message": "hello"},
+		},
+	}
+	if diff := cmp.Diff(expected, l.entries); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestCoreWriteOperation(t *testing.T) {
+	l := &testLogger{}
+	c := &Core{Logger: l}
+
+	for _, f := range []zapcore.Field{
+		zapdriver.OperationStart("op1", "zapdriver"),
+		gologger.OperationEnd("op2", "go-logger"),
+		zap.String(OperationKey, "not an operation"),
+	} {
+		if err := c.Write(zapcore.Entry{Message: "hello"}, []zapcore.Field{f}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := []gcl.Entry{
+		{
+			Operation: &loggingpb.LogEntryOperation{Id: "op1", Producer: "zapdriver", First: true},
+			Payload:   map[string]interface{}{"message": "hello"},
+		},
+		{
+			Operation: &loggingpb.LogEntryOperation{Id: "op2", Producer: "go-logger", Last: true},
+			Payload:   map[string]interface{}{"message": "hello"},
+		},
+		{
+			Payload: map[string]interface{}{"message": "hello", OperationKey: "not an operation"},
+		},
+	}
+	if diff := cmp.Diff(expected, l.entries, protocmp.Transform()); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestCoreWriteHTTPRequest(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://example.com/foo?bar=baz", nil)
+	req.Header.Set("User-Agent", "test")
+
+	tests := []struct {
+		name   string
+		field  zapcore.Field
+		status int
+		size   int64
+		lat    time.Duration
+	}{
+		{"zapdriver", zapdriver.HTTP(&zapdriver.HTTPPayload{
+			RequestMethod: "GET", RequestURL: "http://example.com/foo?bar=baz",
+			UserAgent: "test", Status: 200, ResponseSize: "12", Latency: "1.5s",
+		}), 200, 12, 1500 * time.Millisecond},
+		{"go-logger", gologger.HTTP(&gologger.HTTPPayload{
+			RequestMethod: "GET", RequestURL: "http://example.com/foo?bar=baz",
+			UserAgent: "test", Status: 404, ResponseSize: "3", Latency: "2ms",
+		}), 404, 3, 2 * time.Millisecond},
+		{"gcl", zap.Any(HTTPRequestKey, &gcl.HTTPRequest{
+			Request: req, Status: 500, ResponseSize: 7,
+		}), 500, 7, 0},
+		{"exchange", HTTP(req, 201, 5, time.Second), 201, 5, time.Second},
+		{"std", zap.Any(HTTPRequestKey, req), 0, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := &testLogger{}
+			c := &Core{Logger: l}
+			if err := c.Write(zapcore.Entry{}, []zapcore.Field{tt.field}); err != nil {
+				t.Fatal(err)
+			}
+			r := l.entries[0].HTTPRequest
+			if r == nil {
+				t.Fatal("HTTPRequest not set")
+			}
+			if _, ok := l.entries[0].Payload.(map[string]interface{})[HTTPRequestKey]; ok {
+				t.Error("httpRequest left in payload")
+			}
+			if r.Request.Method != "GET" || r.Request.URL.String() != "http://example.com/foo?bar=baz" || r.Request.UserAgent() != "test" {
+				t.Errorf("unexpected request %v %v %v", r.Request.Method, r.Request.URL, r.Request.UserAgent())
+			}
+			if r.Status != tt.status || r.ResponseSize != tt.size || r.Latency != tt.lat {
+				t.Errorf("got status=%d size=%d latency=%v", r.Status, r.ResponseSize, r.Latency)
+			}
+		})
+	}
+}
+
+func TestCoreWriteUnknownHTTPRequest(t *testing.T) {
+	l := &testLogger{}
+	c := &Core{Logger: l}
+	op := zapdriver.OperationStart("id", "producer")
+	op.Key = HTTPRequestKey
+	if err := c.Write(zapcore.Entry{}, []zapcore.Field{op}); err != nil {
+		t.Fatal(err)
+	}
+	if l.entries[0].HTTPRequest != nil {
+		t.Error("unexpected HTTPRequest")
+	}
+	if _, ok := l.entries[0].Payload.(map[string]interface{})[HTTPRequestKey]; !ok {
+		t.Error("unknown httpRequest value should stay in the payload")
+	}
+}
+
+func TestCoreWriteLabels(t *testing.T) {
+	l := &testLogger{}
+	c := (&Core{Logger: l}).With([]zapcore.Field{
+		zapdriver.Labels(zapdriver.Label("a", "ctx"), zapdriver.Label("b", "ctx")),
+		zap.Int("labels.n", 1),
+	})
+	c = c.With([]zapcore.Field{zapdriver.Labels(zapdriver.Label("c", "ctx"))})
+
+	fields := []zapcore.Field{
+		zapdriver.Labels(zapdriver.Label("b", "entry")),
+		zap.Bool("labels.ok", true),
+		zap.Uint8("labels.u", 7),
+	}
+	if err := c.Write(zapcore.Entry{Message: "hello"}, fields); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := gcl.Entry{
+		Labels: map[string]string{
+			"a": "ctx", "b": "entry", "c": "ctx", "n": "1", "ok": "true", "u": "7",
+		},
+		Payload: map[string]interface{}{"message": "hello"},
+	}
+	if diff := cmp.Diff(expected, l.entries[0]); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestCoreWriteInvalidLabels(t *testing.T) {
+	l := &testLogger{}
+	c := &Core{Logger: l}
+
+	fields := []zapcore.Field{
+		zap.String("labels.good", "yes"),
+		zap.Any("labels.nested", map[string]interface{}{"a": 1}),
+		zap.String("labels."+strings.Repeat("k", 513), "v"),
+	}
+	err := c.Write(zapcore.Entry{Message: "hello"}, fields)
+	if err == nil {
+		t.Fatal("expected an error for invalid labels")
+	}
+	if !strings.Contains(err.Error(), `"nested"`) {
+		t.Errorf("error should name the invalid label: %v", err)
+	}
+	if len(l.entries) != 1 {
+		t.Fatal("entry should still be written")
+	}
+	if diff := cmp.Diff(map[string]string{"good": "yes"}, l.entries[0].Labels); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestCoreWriteErrorReporting(t *testing.T) {
+	l := &testLogger{}
+	c := &Core{Logger: l, ErrorReporting: &ErrorReporting{Service: "svc", Version: "v1"}}
+
+	caller := zapcore.EntryCaller{Defined: true, File: "/src/main.go", Line: 42, Function: "main.run"}
+	e := zapcore.Entry{
+		Level:   zapcore.ErrorLevel,
+		Message: "request 1234 failed",
+		Caller:  caller,
+		Stack:   "main.run\n\t/src/main.go:42\nmain.main\n\t/src/main.go:10",
+	}
+	if err := c.Write(e, nil); err != nil {
+		t.Fatal(err)
+	}
+	e.Message = "request 5678 failed"
+	e.Level = zapcore.InfoLevel
+	if err := c.Write(e, nil); err != nil {
+		t.Fatal(err)
+	}
+	e.Level = zapcore.FatalLevel
+	if err := c.Write(e, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"@type":          reportedErrorEventType,
+		"serviceContext": map[string]interface{}{"service": "svc", "version": "v1"},
+		"context": map[string]interface{}{
+			"reportLocation": map[string]interface{}{
+				"filePath": "/src/main.go", "lineNumber": 42, "functionName": "main.run",
+			},
+		},
+		"message": "request 1234 failed\n\ngoroutine 1 [running]:\n" +
+			"main.run()\n\t/src/main.go:42\nmain.main()\n\t/src/main.go:10\n",
+	}
+	if diff := cmp.Diff(expected, l.entries[0].Payload); diff != "" {
+		t.Error(diff)
+	}
+
+	if _, ok := l.entries[1].Payload.(map[string]interface{})["@type"]; ok {
+		t.Error("info entries must not be reported")
+	}
+
+	fp := l.entries[0].Labels[FingerprintLabel]
+	if fp == "" {
+		t.Fatal("missing fingerprint label")
+	}
+	if fp != l.entries[2].Labels[FingerprintLabel] {
+		t.Error("fingerprint should not depend on numbers in the message")
+	}
+}
+
+func TestCoreWriteOversizeTruncate(t *testing.T) {
+	l := &testLogger{}
+	c := (&Core{Logger: l, MaxEntrySize: 4096}).With([]zapcore.Field{
+		zap.Namespace("req"),
+		zap.String("dump", strings.Repeat("d", 3000)),
+	})
+
+	stack := strings.Repeat("s", 5000)
+	if err := c.Write(zapcore.Entry{Message: "hi", Stack: stack}, []zapcore.Field{zap.Int("n", 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.entries) != 1 {
+		t.Fatalf("expected one entry, got %d", len(l.entries))
+	}
+	p := l.entries[0].Payload.(map[string]interface{})
+	if p[TruncatedKey] != true {
+		t.Error("missing truncated marker")
+	}
+	if s := p["stack"].(string); len(s) >= len(stack) || !strings.HasSuffix(s, truncatedSuffix) {
+		t.Errorf("stack was not truncated: %d bytes", len(s))
+	}
+	if p["message"] != "hi" || p["req"].(map[string]interface{})["n"] != int64(1) {
+		t.Errorf("small fields should be kept: %v", p)
+	}
+	if size := entrySize(&l.entries[0], p); size > 4096 {
+		t.Errorf("entry is still %d bytes", size)
+	}
+
+	// The parent Core's fields must not have been truncated in place.
+	if err := c.Write(zapcore.Entry{Message: "small"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if d := l.entries[1].Payload.(map[string]interface{})["req"].(map[string]interface{})["dump"]; len(d.(string)) != 3000 {
+		t.Errorf("shared field was modified: %d bytes", len(d.(string)))
+	}
+}
+
+func TestCoreWriteOversizeSplit(t *testing.T) {
+	l := &testLogger{}
+	c := &Core{Logger: l, Oversize: OversizeSplit, MaxEntrySize: 4096}
+
+	msg := strings.Repeat("0123456789", 1000)
+	fields := []zapcore.Field{zap.String("small", "x"), zap.String(InsertIDKey, "id")}
+	if err := c.Write(zapcore.Entry{Level: zapcore.ErrorLevel, Message: msg}, fields); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.entries) < 3 {
+		t.Fatalf("expected the entry to be split, got %d entries", len(l.entries))
+	}
+
+	var joined strings.Builder
+	for i, e := range l.entries {
+		p := e.Payload.(map[string]interface{})
+		expected := map[string]interface{}{"uid": "id", "index": i, "totalSplits": len(l.entries)}
+		if diff := cmp.Diff(expected, p[SplitKey]); diff != "" {
+			t.Errorf("entry %d: %s", i, diff)
+		}
+		if p["small"] != "x" {
+			t.Errorf("entry %d: small field missing", i)
+		}
+		if e.InsertID != "id-"+strconv.Itoa(i) {
+			t.Errorf("entry %d: unexpected insert ID %q", i, e.InsertID)
+		}
+		if size := entrySize(&l.entries[i], p); size > 4096 {
+			t.Errorf("entry %d is %d bytes", i, size)
+		}
+		joined.WriteString(p["message"].(string))
+	}
+	if joined.String() != msg {
+		t.Error("pieces don't add up to the message")
+	}
+}
+
+func TestNativeSplits(t *testing.T) {
+	l := &testLogger{}
+	c := &Core{Logger: l, Oversize: OversizeSplit, MaxEntrySize: 4096}
+	msg := strings.Repeat("0123456789", 1000)
+	if err := c.Write(zapcore.Entry{Level: zapcore.ErrorLevel, Message: msg}, []zapcore.Field{zap.String(InsertIDKey, "id")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Write(zapcore.Entry{Message: "other"}, []zapcore.Field{zap.String(SplitKey, "mine")}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &loggingpb.WriteLogEntriesRequest{}
+	for _, e := range l.entries {
+		pe, err := gcl.ToLogEntry(e, "projects/proj")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Entries = append(req.Entries, pe)
+	}
+	invoker := func(context.Context, string, interface{}, interface{}, *grpc.ClientConn, ...grpc.CallOption) error {
+		return nil
+	}
+	if err := nativeSplits(context.Background(), writeLogEntriesMethod, req, nil, nil, invoker); err != nil {
+		t.Fatal(err)
+	}
+
+	pieces := req.Entries[:len(req.Entries)-1]
+	for i, e := range pieces {
+		expected := &loggingpb.LogSplit{Uid: "id", Index: int32(i), TotalSplits: int32(len(pieces))}
+		if diff := cmp.Diff(expected, e.GetSplit(), protocmp.Transform()); diff != "" {
+			t.Errorf("entry %d: %s", i, diff)
+		}
+		if _, ok := e.GetJsonPayload().GetFields()[SplitKey]; ok {
+			t.Errorf("entry %d: the split field was left in the payload", i)
+		}
+	}
+	other := req.Entries[len(req.Entries)-1]
+	if other.GetSplit() != nil || other.GetJsonPayload().GetFields()[SplitKey].GetStringValue() != "mine" {
+		t.Errorf("a split field of another shape must be left alone: %v", other)
+	}
+}
+
 func TestConcurrentCoreWrite(t *testing.T) {
 	l := &testLogger{}
 	c := &Core{Logger: l}
@@ -121,7 +552,7 @@ func TestConcurrentCoreWrite(t *testing.T) {
 		go func() {
 			fields := []zapcore.Field{{Key: "i", Interface: index}}
 			if err := c.Write(zapcore.Entry{}, fields); err != nil {
-				t.Fatal(err)
+				t.Error(err)
 			}
 			wg.Done()
 		}()
@@ -151,6 +582,93 @@ func TestCoreSync(t *testing.T) {
 	}
 }
 
+func TestCoreLoggerNameLogID(t *testing.T) {
+	base := &testLogger{}
+	named := map[string]*testLogger{}
+	c := &Core{
+		Logger:      base,
+		LoggerNames: LoggerNameLogID,
+		loggers: newLoggerCache(func(logID string) GoogleCloudLogger {
+			l := &testLogger{}
+			named[logID] = l
+			return l
+		}),
+	}
+	child := c.With([]zapcore.Field{zap.String("foo", "bar")})
+
+	for _, name := range []string{"", "audit", "audit", "http.access log"} {
+		if err := child.Write(zapcore.Entry{LoggerName: name, Message: "hello"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(base.entries) != 1 {
+		t.Errorf("expected 1 unnamed entry, got %d", len(base.entries))
+	}
+	if len(named) != 2 || len(named["audit"].entries) != 2 || len(named["http.access_log"].entries) != 1 {
+		t.Errorf("unexpected named loggers: %v", named)
+	}
+	if _, ok := named["audit"].entries[0].Payload.(map[string]interface{})["logger"]; ok {
+		t.Error("logger field should not be set when routing by log ID")
+	}
+
+	named["audit"].flushErr = errors.New("audit failed")
+	named["http.access_log"].flushErr = errors.New("access failed")
+	err := c.Sync()
+	if err == nil {
+		t.Fatal("expected a flush error")
+	}
+	if !strings.Contains(err.Error(), "audit failed") || !strings.Contains(err.Error(), "access failed") {
+		t.Errorf("flush errors should be aggregated: %v", err)
+	}
+	if !base.flushed || !named["audit"].flushed {
+		t.Error("all loggers should be flushed")
+	}
+}
+
+func TestNewCore(t *testing.T) {
+	if _, err := NewCore(nil, "log"); err == nil {
+		t.Error("expected an error for a nil client")
+	}
+	if _, err := NewCore(newTestClient(t), "", testResource); err == nil {
+		t.Error("expected an error for an empty log ID")
+	}
+	if _, err := NewCore(newTestClient(t), "log", testResource,
+		WithCommonLabels(map[string]string{"env": "test"}),
+		WithLoggerOptions(gcl.DelayThreshold(time.Millisecond), gcl.PartialSuccess()),
+	); err != nil {
+		t.Error(err)
+	}
+
+	l := &testLogger{}
+	res := &mrpb.MonitoredResource{Type: "global"}
+	c, err := NewCore(nil, "",
+		WithGoogleCloudLogger(l),
+		WithSeverityMapping(map[zapcore.Level]gcl.Severity{zapcore.InfoLevel: gcl.Notice}),
+		WithLevel(zapcore.DebugLevel),
+		WithResource(res),
+		WithCommonLabels(map[string]string{"env": "test"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.Enabled(zapcore.DebugLevel) {
+		t.Error("debug should be enabled")
+	}
+	if err := c.Write(zapcore.Entry{Level: zapcore.InfoLevel, Message: "hello"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	expected := []gcl.Entry{{
+		Severity: gcl.Notice,
+		Resource: res,
+		Labels:   map[string]string{"env": "test"},
+		Payload:  map[string]interface{}{"message": "hello"},
+	}}
+	if diff := cmp.Diff(expected, l.entries, protocmp.Transform()); diff != "" {
+		t.Error(diff)
+	}
+}
+
 func TestCoreLevels(t *testing.T) {
 	c := &Core{MinLevel: zapcore.InfoLevel}
 	if c.Enabled(zapcore.DebugLevel) {
@@ -164,14 +682,15 @@ func TestCoreLevels(t *testing.T) {
 }
 
 type testLogger struct {
-	entries []gcl.Entry
-	mu      sync.Mutex
-	flushed bool
+	entries  []gcl.Entry
+	mu       sync.Mutex
+	flushed  bool
+	flushErr error
 }
 
 func (t *testLogger) Flush() error {
 	t.flushed = true
-	return nil
+	return t.flushErr
 }
 
 func (t *testLogger) Log(e gcl.Entry) {
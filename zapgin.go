@@ -2,25 +2,27 @@
 package zapgcl
 
 import (
-	"net"
-	"net/http"
-	"net/http/httputil"
-	"os"
-	"runtime/debug"
-	"strconv"
-	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	gologger "github.com/govargo/go-logger"
 	"go.uber.org/zap"
 )
 
+// ginLoggerKey is the gin.Context key of the request's logger.
+const ginLoggerKey = "github.com/pigfoot/zapgcl/logger"
+
 // ZapGin returns a gin.HandlerFunc (middleware) that logs requests using uber-go/zap.
 //
 // Requests with errors are logged using zap.Error().
 // Requests without errors are logged using zap.Info().
 //
+// The trace context of each request is taken from its traceparent or
+// X-Cloud-Trace-Context header, or started if it has neither (see
+// TraceContextFromHeader), and echoed in a traceparent response header. The
+// request is logged by a child of logger carrying the trace fields, which
+// handlers get with FromGin, or with L from the request's context, so that
+// their entries are tied to the same trace.
+//
 // It receives:
 //  1. A boolean stating whether to use UTC time zone or local.
 func ZapGin(logger *zap.Logger, utc bool) gin.HandlerFunc {
@@ -28,6 +30,10 @@ func ZapGin(logger *zap.Logger, utc bool) gin.HandlerFunc {
 		start := time.Now()
 		// some evil middlewares modify this values
 		path := c.Request.URL.Path
+
+		logger, req := traceRequest(logger, c.Writer.Header(), c.Request)
+		c.Request = req
+		c.Set(ginLoggerKey, logger)
 		c.Next()
 
 		end := time.Now()
@@ -42,11 +48,7 @@ func ZapGin(logger *zap.Logger, utc bool) gin.HandlerFunc {
 				logger.Error(e)
 			}
 		} else {
-			res := &http.Response{StatusCode: c.Writer.Status()}
-			httpPayload := gologger.NewHTTP(c.Request, res)
-			httpPayload.Latency = latency.String()
-			httpPayload.ResponseSize = strconv.Itoa(c.Writer.Size())
-			logger.Info(path, gologger.HTTP(httpPayload))
+			logRequest(logger, c.Request, path, c.Writer.Status(), int64(c.Writer.Size()), latency)
 		}
 	}
 }
@@ -56,50 +58,61 @@ func ZapGin(logger *zap.Logger, utc bool) gin.HandlerFunc {
 // All errors are logged using zap.Error().
 // stack means whether output the stack info.
 // The stack info is easy to find where the error occurs but the stack info is too large.
+//
+// It is GinRecovery(logger, WithStack(stack)).
 func RecoveryWithZap(logger *zap.Logger, stack bool) gin.HandlerFunc {
+	return GinRecovery(logger, WithStack(stack))
+}
+
+// GinRecovery returns a gin.HandlerFunc (middleware) that recovers from
+// panics with any value, which it wraps in a PanicError.
+//
+// Panics are logged with the request's logger (see FromGin), or else with
+// logger, at ErrorLevel, with a "panic: <value>" message followed by the
+// stack if WithStack is set, which Error Reporting groups like the crash of a
+// Go program, and a dump of the request whose sensitive headers are masked
+// (see WithMaskedHeaders). The response is written by the RecoveryHandler
+// (see WithRecoveryHandler), a bare 500 by default.
+//
+// If the connection is broken, which errors.Is tells from http.ErrAbortHandler,
+// EPIPE and ECONNRESET, the panic is logged without a stack, added to the
+// errors of c and nothing is written.
+func GinRecovery(logger *zap.Logger, opts ...MiddlewareOption) gin.HandlerFunc {
+	o := newMiddlewareOptions(opts)
 	return func(c *gin.Context) {
 		defer func() {
-			if err := recover(); err != nil {
-				// Check for a broken connection, as it is not really a
-				// condition that warrants a panic stack trace.
-				var brokenPipe bool
-				if ne, ok := err.(*net.OpError); ok {
-					if se, ok := ne.Err.(*os.SyscallError); ok {
-						if strings.Contains(strings.ToLower(se.Error()), "broken pipe") || strings.Contains(strings.ToLower(se.Error()), "connection reset by peer") {
-							brokenPipe = true
-						}
-					}
-				}
-
-				httpRequest, _ := httputil.DumpRequest(c.Request, false)
-				if brokenPipe {
-					logger.Error(c.Request.URL.Path,
-						zap.String("error", err.(string)),
-						zap.String("request", string(httpRequest)),
-					)
-					// If the connection is dead, we can't write a status to it.
-					c.Error(err.(error)) // nolint: errcheck
-					c.Abort()
-					return
-				}
+			v := recover()
+			if v == nil {
+				return
+			}
 
-				if stack {
-					logger.Error("[Recovery from panic]",
-						zap.Time("time", time.Now()),
-						zap.String("error", err.(string)),
-						zap.String("request", string(httpRequest)),
-						zap.String("stack", string(debug.Stack())),
-					)
-				} else {
-					logger.Error("[Recovery from panic]",
-						zap.Time("time", time.Now()),
-						zap.String("error", err.(string)),
-						zap.String("request", string(httpRequest)),
-					)
+			l := logger
+			if rl, ok := c.Get(ginLoggerKey); ok {
+				if rl, ok := rl.(*zap.Logger); ok {
+					l = rl
 				}
-				c.AbortWithStatus(http.StatusInternalServerError)
 			}
+			err, broken := o.logPanic(l, c.Request, v)
+			if broken {
+				// If the connection is dead, we can't write a status to it.
+				c.Error(err) // nolint: errcheck
+				c.Abort()
+				return
+			}
+			o.recover(c.Writer, c.Request, err)
+			c.Abort()
 		}()
 		c.Next()
 	}
 }
+
+// FromGin returns the logger ZapGin stored in c, which carries the trace
+// fields of the request, or zap.L() if there is none.
+func FromGin(c *gin.Context) *zap.Logger {
+	if v, ok := c.Get(ginLoggerKey); ok {
+		if l, ok := v.(*zap.Logger); ok {
+			return l
+		}
+	}
+	return zap.L()
+}
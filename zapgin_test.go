@@ -0,0 +1,185 @@
+package zapgcl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+func TestParseTraceContext(t *testing.T) {
+	for _, tc := range []struct {
+		traceparent, cloud string
+		expected           TraceContext
+		ok                 bool
+	}{
+		{
+			traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+			expected:    TraceContext{TraceID: "4bf92f3577b34da6a3ce929d0e0e4736", SpanID: "00f067aa0ba902b7", Sampled: true},
+			ok:          true,
+		},
+		{
+			cloud:    "105445AA7843BC8BF206B12000100000/1;o=1",
+			expected: TraceContext{TraceID: "105445aa7843bc8bf206b12000100000", SpanID: "0000000000000001", Sampled: true},
+			ok:       true,
+		},
+		{
+			cloud:    "105445aa7843bc8bf206b12000100000",
+			expected: TraceContext{TraceID: "105445aa7843bc8bf206b12000100000"},
+			ok:       true,
+		},
+		{traceparent: "00-00000000000000000000000000000000-00f067aa0ba902b7-01"},
+		{traceparent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"},
+		{traceparent: "ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+		{cloud: "nothex/1;o=1"},
+		{cloud: "105445aa7843bc8bf206b12000100000/x"},
+	} {
+		var got TraceContext
+		var ok bool
+		if tc.traceparent != "" {
+			got, ok = ParseTraceparent(tc.traceparent)
+		} else {
+			got, ok = ParseCloudTraceContext(tc.cloud)
+		}
+		if ok != tc.ok || got != tc.expected {
+			t.Errorf("%q%q: got %+v, %v", tc.traceparent, tc.cloud, got, ok)
+		}
+	}
+
+	started := TraceContextFromHeader(http.Header{})
+	if !isHexID(started.TraceID, 32) || !isHexID(started.SpanID, 16) || started.Sampled {
+		t.Errorf("unexpected new trace %+v", started)
+	}
+	if _, ok := ParseTraceparent(started.Traceparent()); !ok {
+		t.Errorf("invalid traceparent %q", started.Traceparent())
+	}
+}
+
+func TestZapGinTraceContext(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tl := &testLogger{}
+	logger := zap.New(&Core{Logger: tl, ProjectID: "proj", SeverityMapping: DefaultSeverityMapping})
+
+	r := gin.New()
+	r.Use(ZapGin(logger, true))
+	r.GET("/ping", func(c *gin.Context) {
+		FromGin(c).Info("handler")
+		c.String(http.StatusOK, "pong")
+	})
+
+	req := httptest.NewRequest("GET", "/ping", nil)
+	req.Header.Set(CloudTraceContextHeader, "105445aa7843bc8bf206b12000100000/1;o=1")
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+
+	expected := "00-105445aa7843bc8bf206b12000100000-0000000000000001-01"
+	if got := w.Header().Get(TraceparentHeader); got != expected {
+		t.Errorf("unexpected traceparent %q", got)
+	}
+	if len(tl.entries) != 2 {
+		t.Fatalf("expected two entries, got %d", len(tl.entries))
+	}
+	for _, e := range tl.entries {
+		if e.Trace != "projects/proj/traces/105445aa7843bc8bf206b12000100000" || e.SpanID != "0000000000000001" || !e.TraceSampled {
+			t.Errorf("entry not tied to the trace: %+v", e)
+		}
+	}
+
+	// Without a trace header, one is started.
+	tl.entries = nil
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/ping", nil))
+	tc, ok := ParseTraceparent(w.Header().Get(TraceparentHeader))
+	if !ok || len(tl.entries) != 2 || !strings.HasSuffix(tl.entries[0].Trace, tc.TraceID) {
+		t.Errorf("unexpected trace %+v for %+v", tc, tl.entries)
+	}
+}
+
+func TestFromGinDefault(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	if FromGin(c) != zap.L() {
+		t.Error("expected the global logger")
+	}
+}
+
+type panicPoint struct{ X, Y int }
+
+func TestGinRecovery(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tl := &testLogger{}
+	logger := zap.New(&Core{Logger: tl, ProjectID: "proj", SeverityMapping: DefaultSeverityMapping})
+
+	var value interface{}
+	r := gin.New()
+	r.Use(ZapGin(logger, true), GinRecovery(logger, WithStack(true), WithRecoveryHandler(JSONRecoveryHandler)))
+	r.GET("/panic", func(*gin.Context) { panic(value) })
+
+	for _, tc := range []struct {
+		value interface{}
+		err   string
+	}{
+		{"boom", "boom"},
+		{http.ErrBodyNotAllowed, http.ErrBodyNotAllowed.Error()},
+		{net.IPv4(127, 0, 0, 1), "127.0.0.1"},
+		{panicPoint{1, 2}, "{1 2}"},
+	} {
+		tl.entries = nil
+		value = tc.value
+		req := httptest.NewRequest("GET", "/panic", nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Cookie", "session=secret")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		var body map[string]map[string]interface{}
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil || w.Code != http.StatusInternalServerError || body["error"]["status"] != "INTERNAL" {
+			t.Errorf("%v: unexpected response %d %s", tc.value, w.Code, w.Body)
+		}
+		if len(tl.entries) != 2 {
+			t.Fatalf("%v: expected two entries, got %d", tc.value, len(tl.entries))
+		}
+		e := tl.entries[0]
+		payload := e.Payload.(map[string]interface{})
+		msg := payload["message"].(string)
+		if !strings.HasPrefix(msg, "panic: "+tc.err+"\n\ngoroutine ") || payload["error"] != tc.err || e.Trace == "" {
+			t.Errorf("%v: unexpected entry %+v", tc.value, e)
+		}
+		if dump := payload["request"].(string); strings.Contains(dump, "secret") || !strings.Contains(dump, "Authorization: "+DefaultRedactionMask) {
+			t.Errorf("%v: headers not masked in %q", tc.value, dump)
+		}
+	}
+
+	// A broken connection gets neither a stack nor a response.
+	tl.entries = nil
+	value = &net.OpError{Op: "write", Err: os.NewSyscallError("write", syscall.EPIPE)}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/panic", nil))
+	if w.Body.Len() != 0 || len(tl.entries) != 2 {
+		t.Fatalf("unexpected response %q and entries %+v", w.Body, tl.entries)
+	}
+	if msg := tl.entries[0].Payload.(map[string]interface{})["message"]; msg != "/panic" {
+		t.Errorf("unexpected message %v", msg)
+	}
+}
+
+func TestPanicError(t *testing.T) {
+	err := error(&PanicError{Value: fmt.Errorf("wrapped: %w", syscall.ECONNRESET)})
+	if !errors.Is(err, syscall.ECONNRESET) || !isBrokenConnection(err) {
+		t.Error("expected the panic value to be unwrapped")
+	}
+	if isBrokenConnection(&PanicError{Value: "broken pipe"}) {
+		t.Error("expected a string not to be a broken connection")
+	}
+	if !isBrokenConnection(&PanicError{Value: http.ErrAbortHandler}) {
+		t.Error("expected http.ErrAbortHandler to be a broken connection")
+	}
+}